@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// LogEntry is a single log message stored in a shard.
+type LogEntry struct {
+	Time  time.Time `json:"time"`
+	Node  int       `json:"node"`
+	Shard int       `json:"shard"`
+	Msg   string    `json:"msg"`
+}
+
+// Shard holds the log entries of one time slice on one node.
+// The entries are sorted by time stamp.
+type Shard struct {
+	Node    int
+	ID      int
+	Span    TimeRange
+	entries []LogEntry
+}
+
+func (s *Shard) String() string {
+	return fmt.Sprintf("node %d shard %d", s.Node, s.ID)
+}
+
+// Simulate reading the shard from disk: every block of
+// entries takes some time to load.
+const (
+	blockSize = 100
+	blockTime = 2 * time.Millisecond
+)
+
+// search scans the shard for entries that match q and sends them to res
+// in time stamp order. It returns the number of scanned entries, and
+// the context's error if ctx is canceled before the scan completes.
+func (s *Shard) search(ctx context.Context, q Query, res chan<- LogEntry) (int, error) {
+	scanned := 0
+	for i, e := range s.entries {
+		if i%blockSize == 0 {
+			select {
+			case <-ctx.Done():
+				return scanned, ctx.Err()
+			case <-time.After(blockTime):
+			}
+		}
+		scanned++
+		if !q.matches(e) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return scanned, ctx.Err()
+		case res <- e:
+		}
+	}
+	return scanned, nil
+}
+
+var messages = []string{
+	"pid=5543 started",
+	"pid=5543 exited with status 0",
+	"GET /index.html HTTP_200",
+	"GET /teapot HTTP_418",
+	"POST /login HTTP_401",
+	"CON_RST by peer 10.0.0.7",
+	"cache miss for key user:42",
+	"disk usage at 81%",
+}
+
+// newCluster creates nodes*shardsPerNode shards with perShard random entries each.
+// Every shard covers one slice of the given length, ending at end.
+// Consecutive slices are distributed round-robin across the nodes.
+func newCluster(end time.Time, nodes, shardsPerNode int, slice time.Duration, perShard int) []*Shard {
+	n := nodes * shardsPerNode
+	shards := make([]*Shard, 0, n)
+	for k := 0; k < n; k++ {
+		from := end.Add(-time.Duration(n-k) * slice)
+		s := &Shard{
+			Node: k % nodes,
+			ID:   k / nodes,
+			Span: TimeRange{From: from, To: from.Add(slice)},
+		}
+		s.entries = make([]LogEntry, perShard)
+		for i := range s.entries {
+			s.entries[i] = LogEntry{
+				Time:  from.Add(time.Duration(rand.Int63n(int64(slice)))),
+				Node:  s.Node,
+				Shard: s.ID,
+				Msg:   messages[rand.Intn(len(messages))],
+			}
+		}
+		sort.Slice(s.entries, func(i, j int) bool {
+			return s.entries[i].Time.Before(s.entries[j].Time)
+		})
+		shards = append(shards, s)
+	}
+	return shards
+}
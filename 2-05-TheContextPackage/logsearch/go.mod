@@ -0,0 +1,10 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/2-05-TheContextPackage/logsearch
+
+go 1.19
+
+require (
+	github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool v0.0.0
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+)
+
+replace github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool => ../../workerpool
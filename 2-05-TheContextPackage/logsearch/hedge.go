@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Replica is a copy of a shard on another node.
+// Replicas answer the same queries, but each one has its own latency.
+type Replica struct {
+	Shard *Shard
+	ID    int
+
+	// search replaces the simulated search, for tests.
+	search func(ctx context.Context, q Query) ([]LogEntry, error)
+}
+
+func (r *Replica) String() string {
+	return fmt.Sprintf("%s replica %d", r.Shard, r.ID)
+}
+
+// replicasOf creates n replicas of s.
+func replicasOf(s *Shard, n int) []*Replica {
+	rs := make([]*Replica, n)
+	for i := range rs {
+		rs[i] = &Replica{Shard: s, ID: i}
+	}
+	return rs
+}
+
+// latency simulates the response time of a replica.
+// Most requests are fast, but a few hit a busy node
+// and take an order of magnitude longer.
+func (r *Replica) latency() time.Duration {
+	if rand.Intn(100) < 4 {
+		return time.Duration(300+rand.Intn(300)) * time.Millisecond
+	}
+	return time.Duration(20+rand.Intn(30)) * time.Millisecond
+}
+
+// Search returns all entries of the replica that match q.
+// It returns the context's error if ctx is canceled
+// before the replica answers.
+func (r *Replica) Search(ctx context.Context, q Query) ([]LogEntry, error) {
+	if r.search != nil {
+		return r.search(ctx, q)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(r.latency()):
+	}
+	var res []LogEntry
+	for _, e := range r.Shard.entries {
+		if q.matches(e) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// latencyTracker keeps the most recent latencies in a ring buffer.
+// It is safe for concurrent use.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyTracker(size int) *latencyTracker {
+	return &latencyTracker{samples: make([]time.Duration, size)}
+}
+
+// Observe records a latency.
+func (t *latencyTracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % len(t.samples)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Percentile returns the p-th percentile (0 < p <= 1) of the recorded
+// latencies, and the number of samples it is based on.
+func (t *latencyTracker) Percentile(p float64) (time.Duration, int) {
+	t.mu.Lock()
+	n := t.next
+	if t.full {
+		n = len(t.samples)
+	}
+	s := make([]time.Duration, n)
+	copy(s, t.samples[:n])
+	t.mu.Unlock()
+
+	if n == 0 {
+		return 0, 0
+	}
+	return percentile(s, p), n
+}
+
+// MeanAbove returns the mean of the recorded latencies that are longer
+// than d, and their number.
+func (t *latencyTracker) MeanAbove(d time.Duration) (time.Duration, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.next
+	if t.full {
+		n = len(t.samples)
+	}
+	var sum time.Duration
+	count := 0
+	for _, s := range t.samples[:n] {
+		if s > d {
+			sum += s
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / time.Duration(count), count
+}
+
+// percentile sorts s and returns its p-th percentile.
+func percentile(s []time.Duration, p float64) time.Duration {
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(float64(len(s))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i]
+}
+
+// HedgeStats counts the hedged requests of a Hedger.
+type HedgeStats struct {
+	Requests int // number of calls to Search
+	Fired    int // number of hedged requests issued
+	Won      int // number of hedged requests that answered first
+
+	// Saved is the estimated tail latency that the won hedges saved,
+	// in total. When a hedge wins, the primary request is canceled, so
+	// its latency is unknown; it is only known to be longer than the
+	// time the hedge took to win. The estimate is the mean of the
+	// observed latencies above that time, minus that time.
+	Saved time.Duration
+}
+
+// Hedger sends a query to a replica, and if the replica has not answered
+// within the observed latency percentile, sends the same query to
+// another replica. The first answer wins, and the remaining requests
+// are canceled through their context.
+type Hedger struct {
+	// Percentile of the observed latencies after which a hedged request is sent.
+	Percentile float64
+
+	// MinSamples is the number of observed latencies required
+	// before the percentile is trusted. Until then, the hedger waits
+	// for Fallback before sending a hedged request.
+	MinSamples int
+	Fallback   time.Duration
+
+	// MaxHedges is the maximum number of hedged requests per query.
+	// Zero disables hedging.
+	MaxHedges int
+
+	latencies *latencyTracker
+
+	mu    sync.Mutex
+	stats HedgeStats
+}
+
+// NewHedger returns a Hedger that sends at most one hedged request,
+// once a replica is slower than the 95th percentile of the last 1000 answers.
+func NewHedger() *Hedger {
+	return &Hedger{
+		Percentile: 0.95,
+		MinSamples: 50,
+		Fallback:   100 * time.Millisecond,
+		MaxHedges:  1,
+		latencies:  newLatencyTracker(1000),
+	}
+}
+
+// Stats returns a snapshot of the hedging statistics.
+func (h *Hedger) Stats() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// delay returns the time to wait for an answer before hedging.
+func (h *Hedger) delay() time.Duration {
+	d, n := h.latencies.Percentile(h.Percentile)
+	if n < h.MinSamples {
+		return h.Fallback
+	}
+	return d
+}
+
+type replicaResult struct {
+	entries []LogEntry
+	err     error
+	attempt int
+	hedged  bool // sent because the hedge delay passed
+}
+
+// Search queries the replicas of a shard, starting with the first one.
+// Every time the hedge delay passes without an answer, the next replica
+// receives the same query, up to MaxHedges times. If all outstanding
+// requests have failed, the next replica receives the query right away,
+// which is no hedge, and the hedge delay starts over.
+// Search returns the first successful answer and cancels all other
+// requests, or returns an error if all attempted replicas fail.
+func (h *Hedger) Search(ctx context.Context, replicas []*Replica, q Query) ([]LogEntry, error) {
+	if len(replicas) == 0 {
+		return nil, errors.New("hedger: no replicas")
+	}
+
+	// All outstanding requests share this context and get
+	// canceled when Search returns.
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+
+	// The channel is large enough for every replica to deliver
+	// its result without blocking, even after Search has returned.
+	results := make(chan replicaResult, len(replicas))
+	launched := 0
+	launch := func(hedged bool) {
+		r, attempt := replicas[launched], launched
+		launched++
+		go func() {
+			start := time.Now()
+			entries, err := r.Search(ctx, q)
+			switch {
+			case err == nil:
+				h.latencies.Observe(time.Since(start))
+			case attempt == 0 && ctx.Err() != nil && parent.Err() == nil:
+				// A hedge won, and the primary was canceled. Its
+				// latency is at least the time it has run so far.
+				// Leaving it out would let the percentile drift down,
+				// and hedges would fire more and more often.
+				h.latencies.Observe(time.Since(start))
+			}
+			results <- replicaResult{entries: entries, err: err, attempt: attempt, hedged: hedged}
+		}()
+	}
+
+	h.mu.Lock()
+	h.stats.Requests++
+	h.mu.Unlock()
+
+	launch(false)
+	hedge := time.NewTimer(h.delay())
+	defer hedge.Stop()
+
+	fired := 0
+	var errs []error
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-hedge.C:
+			if fired < h.MaxHedges && launched < len(replicas) {
+				fired++
+				h.mu.Lock()
+				h.stats.Fired++
+				h.mu.Unlock()
+				launch(true)
+				hedge.Reset(h.delay())
+			}
+		case res := <-results:
+			if res.err == nil {
+				if res.hedged {
+					won := time.Since(start)
+					saved := time.Duration(0)
+					if mean, n := h.latencies.MeanAbove(won); n > 0 {
+						saved = mean - won
+					}
+					h.mu.Lock()
+					h.stats.Won++
+					h.stats.Saved += saved
+					h.mu.Unlock()
+				}
+				return res.entries, nil
+			}
+			errs = append(errs, res.err)
+			if len(errs) == launched {
+				if launched == len(replicas) {
+					return nil, fmt.Errorf("hedger: all %d replicas failed, first error: %w", len(errs), errs[0])
+				}
+				launch(false)
+				// The new request gets the full hedge delay. The
+				// timer may have fired already, or may be about to
+				// fire for the requests that failed.
+				if !hedge.Stop() {
+					select {
+					case <-hedge.C:
+					default:
+					}
+				}
+				hedge.Reset(h.delay())
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replicaCalls counts what happened to the requests of scripted replicas.
+type replicaCalls struct {
+	started, canceled atomic.Int32
+}
+
+// scripted returns replicas that answer after the given delays, with an
+// entry that carries their index as the shard number. A negative delay
+// makes the replica fail right away.
+func scripted(calls *replicaCalls, delays ...time.Duration) []*Replica {
+	rs := make([]*Replica, len(delays))
+	for i, d := range delays {
+		i, d := i, d
+		rs[i] = &Replica{ID: i, search: func(ctx context.Context, q Query) ([]LogEntry, error) {
+			calls.started.Add(1)
+			if d < 0 {
+				return nil, errors.New("replica down")
+			}
+			select {
+			case <-ctx.Done():
+				calls.canceled.Add(1)
+				return nil, ctx.Err()
+			case <-time.After(d):
+				return []LogEntry{{Shard: i}}, nil
+			}
+		}}
+	}
+	return rs
+}
+
+// testHedger returns a hedger whose percentile is trusted from the
+// first sample, and whose tracker holds n samples of latency d.
+func testHedger(n int, d time.Duration) *Hedger {
+	h := NewHedger()
+	h.MinSamples = 1
+	h.Fallback = time.Hour
+	for i := 0; i < n; i++ {
+		h.latencies.Observe(d)
+	}
+	return h
+}
+
+func TestHedgeFiresAfterPercentile(t *testing.T) {
+	h := testHedger(100, 20*time.Millisecond)
+	var calls replicaCalls
+	start := time.Now()
+	res, err := h.Search(context.Background(), scripted(&calls, time.Second, 5*time.Millisecond), Query{})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Shard != 1 {
+		t.Errorf("got %v, want the answer of the hedge", res)
+	}
+	// The hedge fires at p95 = 20ms, and answers 5ms later.
+	if elapsed < 20*time.Millisecond || elapsed > 200*time.Millisecond {
+		t.Errorf("answer after %v, want about 25ms", elapsed)
+	}
+	if st := h.Stats(); st.Requests != 1 || st.Fired != 1 || st.Won != 1 {
+		t.Errorf("stats = %+v, want 1 request, 1 fired, 1 won", st)
+	}
+}
+
+func TestHedgeFirstAnswerWins(t *testing.T) {
+	h := testHedger(100, 10*time.Millisecond)
+	h.MaxHedges = 2
+	var calls replicaCalls
+	// The primary and the second hedge are slow, the first hedge is fast.
+	res, err := h.Search(context.Background(), scripted(&calls, time.Second, 15*time.Millisecond, time.Second), Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Shard != 1 {
+		t.Errorf("answer from replica %d, want 1", res[0].Shard)
+	}
+	// The other requests are canceled when Search returns.
+	deadline := time.Now().Add(time.Second)
+	for calls.canceled.Load() != calls.started.Load()-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c, s := calls.canceled.Load(), calls.started.Load(); c != s-1 {
+		t.Errorf("%d of %d requests canceled, want all but the winner", c, s)
+	}
+}
+
+func TestHedgeMaxHedges(t *testing.T) {
+	h := testHedger(100, 5*time.Millisecond)
+	h.MaxHedges = 2
+	var calls replicaCalls
+	d := 100 * time.Millisecond
+	if _, err := h.Search(context.Background(), scripted(&calls, d, d, d, d, d), Query{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.started.Load(); n != 3 {
+		t.Errorf("%d replicas queried, want 3", n)
+	}
+	if st := h.Stats(); st.Fired != 2 {
+		t.Errorf("%d hedges fired, want 2", st.Fired)
+	}
+}
+
+func TestHedgeFailover(t *testing.T) {
+	// No hedge would fire for an hour. Failed replicas must
+	// not wait for the hedge delay.
+	h := testHedger(0, 0)
+	h.MinSamples = 1000
+	var calls replicaCalls
+	start := time.Now()
+	res, err := h.Search(context.Background(), scripted(&calls, -1, -1, 5*time.Millisecond), Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Shard != 2 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("answer from replica %d after %v, want replica 2 right away", res[0].Shard, time.Since(start))
+	}
+	if st := h.Stats(); st.Fired != 0 || st.Won != 0 {
+		t.Errorf("stats = %+v, want 0 fired, 0 won: failover is no hedge", st)
+	}
+
+	if _, err := h.Search(context.Background(), scripted(&calls, -1, -1, -1), Query{}); err == nil {
+		t.Error("no error from three failed replicas")
+	}
+}
+
+func TestHedgeAfterFailover(t *testing.T) {
+	h := testHedger(100, 20*time.Millisecond)
+	var calls replicaCalls
+	// The primary fails, the replica it fails over to is slow, and
+	// a hedge 20ms later gets the answer.
+	start := time.Now()
+	res, err := h.Search(context.Background(), scripted(&calls, -1, time.Second, 5*time.Millisecond), Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Shard != 2 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("answer from replica %d after %v, want replica 2 after about 25ms", res[0].Shard, time.Since(start))
+	}
+	if st := h.Stats(); st.Fired != 1 || st.Won != 1 {
+		t.Errorf("stats = %+v, want 1 fired, 1 won", st)
+	}
+}
+
+func TestHedgeRecordsCanceledPrimary(t *testing.T) {
+	h := testHedger(100, 10*time.Millisecond)
+	var calls replicaCalls
+	if _, err := h.Search(context.Background(), scripted(&calls, time.Second, 30*time.Millisecond), Query{}); err != nil {
+		t.Fatal(err)
+	}
+	// The hedge won after about 40ms; the canceled primary counts as
+	// a sample of at least that long.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if d, n := h.latencies.MeanAbove(35 * time.Millisecond); n == 1 && d >= 35*time.Millisecond {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("the canceled primary was not recorded")
+}
+
+func TestHedgeSaved(t *testing.T) {
+	// p95 is 10ms, and 3% of the answers take 500ms.
+	h := testHedger(97, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		h.latencies.Observe(500 * time.Millisecond)
+	}
+	var calls replicaCalls
+	if _, err := h.Search(context.Background(), scripted(&calls, time.Second, 5*time.Millisecond), Query{}); err != nil {
+		t.Fatal(err)
+	}
+	// The hedge won after about 15ms; slow answers take 500ms.
+	st := h.Stats()
+	if st.Saved < 300*time.Millisecond || st.Saved > 500*time.Millisecond {
+		t.Errorf("saved %v, want about 485ms", st.Saved)
+	}
+}
+
+func TestLatencyTrackerPercentile(t *testing.T) {
+	tr := newLatencyTracker(3)
+	if d, n := tr.Percentile(0.95); d != 0 || n != 0 {
+		t.Errorf("empty: Percentile = %v, %d, want 0, 0", d, n)
+	}
+	tr.Observe(7 * time.Millisecond)
+	for _, p := range []float64{0.01, 0.5, 1} {
+		if d, n := tr.Percentile(p); d != 7*time.Millisecond || n != 1 {
+			t.Errorf("one sample: Percentile(%v) = %v, %d, want 7ms, 1", p, d, n)
+		}
+	}
+	// Five samples in a ring of three: the first two are gone.
+	for _, ms := range []time.Duration{100, 1, 2, 3} {
+		tr.Observe(ms * time.Millisecond)
+	}
+	if d, n := tr.Percentile(1); d != 3*time.Millisecond || n != 3 {
+		t.Errorf("full ring: Percentile(1) = %v, %d, want 3ms, 3", d, n)
+	}
+	if d, _ := tr.Percentile(0.01); d != time.Millisecond {
+		t.Errorf("full ring: Percentile(0.01) = %v, want 1ms", d)
+	}
+}
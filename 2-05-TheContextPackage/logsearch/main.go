@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool"
+	"golang.org/x/sync/errgroup"
+)
+
+/*
+This example extends the distributed log database from the
+withoutcontext, withcancel, and withtimeout examples.
+
+Every shard covers a time slice, and the slices are distributed
+across the nodes. A query can now specify a time span. The planner
+uses the span to route the query to those shards only whose time
+slice overlaps the span. A query without a time span still runs
+across all nodes and shards.
+
+Every shard query gets its own context, derived from the parent
+context, so that each shard has a deadline that ends before the
+parent's deadline.
+
+Shard results can also be merged into a single stream that is
+ordered by time stamp. When the merge has received enough results,
+it cancels the shards that cannot contribute anymore.
+
+Finally, every shard has replicas. If a replica is slower than most
+of the recent answers, a hedged request goes to another replica, and
+the first answer wins.
+
+The pool demo runs the shard searches on a worker pool that grows
+while shard searches queue up, and shrinks when the workers are idle,
+rather than starting one goroutine per shard.
+
+The serve demo exposes the search as an HTTP endpoint. The query
+context is derived from the request context, so that a client that
+disconnects cancels all shard goroutines of its query.
+*/
+
+const (
+	numNodes        = 3
+	shardsPerNode   = 8
+	sliceLen        = time.Hour
+	entriesPerShard = 5000
+)
+
+// run executes q and prints the fan-out cost.
+func run(p *Planner, name string, q Query) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plan := p.Plan(q)
+	logs := make(chan LogEntry)
+	matches := make(chan int)
+
+	// Count the results while the shards are searched.
+	go func() {
+		n := 0
+		for range logs {
+			n++
+		}
+		matches <- n
+	}()
+
+	stats := p.Execute(ctx, plan, logs)
+
+	fmt.Printf("%s query over %s:\n", name, q.Span)
+	fmt.Printf("  shards queried: %d of %d (%d failed)\n", len(stats.Shards), stats.Total, stats.Failed())
+	fmt.Printf("  entries scanned: %d\n", stats.Scanned())
+	fmt.Printf("  matches: %d\n", <-matches)
+	fmt.Printf("  shard time: %s, wall time: %s\n", stats.ShardTime().Round(time.Millisecond), stats.Elapsed.Round(time.Millisecond))
+}
+
+// route compares the fan-out cost of a full-scan query
+// with that of a query routed by time span.
+func route(p *Planner, now time.Time) {
+	match := Contains("HTTP_418")
+
+	// Without a time span, every shard has to be searched.
+	run(p, "Full-scan", Query{Match: match})
+
+	// With a time span, only the shards of the last two hours are searched.
+	run(p, "Routed", Query{Span: Last(2*time.Hour, now), Match: match})
+}
+
+// pool runs a few full-scan queries on a dynamic worker pool,
+// and prints the scaling decisions of the pool.
+func pool(p *Planner, now time.Time) {
+	p.Pool = workerpool.New(workerpool.Options{
+		Min:         1,
+		Max:         8,
+		IdleTimeout: 200 * time.Millisecond,
+		OnScale: func(e workerpool.ScaleEvent) {
+			fmt.Println("  pool:", e)
+		},
+	})
+
+	for i := 1; i <= 3; i++ {
+		run(p, fmt.Sprintf("Pooled #%d", i), Query{Match: Contains("HTTP_418")})
+	}
+
+	// After a while without queries, the workers retire.
+	time.Sleep(500 * time.Millisecond)
+	p.Pool.Close()
+	m := p.Pool.Metrics()
+	fmt.Printf("pool: peak %d workers, %d started, %d retired, %d shard searches\n",
+		m.PeakWorkers, m.ScaleUps, m.ScaleDowns, m.Completed)
+}
+
+// merge prints the first results of a query in time stamp order.
+func merge(p *Planner, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const limit = 10
+	q := Query{Span: Last(6*time.Hour, now), Match: Contains("CON_RST")}
+	streams, wait := p.Streams(ctx, p.Plan(q))
+
+	i := 0
+	for e := range Merge(ctx, limit, streams) {
+		i++
+		fmt.Printf("Result %d: %s node %d shard %d: %s\n", i, e.Time.Format(time.Stamp), e.Node, e.Shard, e.Msg)
+	}
+
+	// Merge has canceled all shards, so wait returns right away.
+	stats := wait()
+	fmt.Printf("shards queried: %d, canceled: %d, entries scanned: %d, wall time: %s\n",
+		len(stats.Shards), stats.Canceled(), stats.Scanned(), stats.Elapsed.Round(time.Millisecond))
+}
+
+// hedge runs the same shard requests with and without hedging
+// and compares the latency distribution.
+func hedge(p *Planner, now time.Time) {
+	const rounds = 40
+	replicas := make([][]*Replica, len(p.Shards))
+	for i, s := range p.Shards {
+		replicas[i] = replicasOf(s, 3)
+	}
+	q := Query{Span: Last(24*time.Hour, now), Match: Contains("pid=5543")}
+
+	measure := func(h *Hedger) []time.Duration {
+		var mu sync.Mutex
+		var latencies []time.Duration
+		for r := 0; r < rounds; r++ {
+			var g errgroup.Group
+			for _, rs := range replicas {
+				rs := rs
+				g.Go(func() error {
+					start := time.Now()
+					_, err := h.Search(context.Background(), rs, q)
+					mu.Lock()
+					latencies = append(latencies, time.Since(start))
+					mu.Unlock()
+					return err
+				})
+			}
+			if err := g.Wait(); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return latencies
+	}
+
+	report := func(name string, l []time.Duration) time.Duration {
+		// percentile sorts l, so the last element is the maximum.
+		p99 := percentile(l, 0.99)
+		fmt.Printf("%-12s p50: %4dms  p95: %4dms  p99: %4dms  max: %4dms\n", name,
+			percentile(l, 0.5).Milliseconds(), percentile(l, 0.95).Milliseconds(),
+			p99.Milliseconds(), l[len(l)-1].Milliseconds())
+		return p99
+	}
+
+	plain := NewHedger()
+	plain.MaxHedges = 0
+	before := report("Not hedged:", measure(plain))
+
+	hedged := NewHedger()
+	after := report("Hedged:", measure(hedged))
+
+	st := hedged.Stats()
+	fmt.Printf("requests: %d, hedges fired: %d, hedges won: %d\n", st.Requests, st.Fired, st.Won)
+	if st.Won > 0 {
+		fmt.Printf("estimated latency saved by won hedges: %s in total, %s per won hedge\n",
+			st.Saved.Round(time.Millisecond), (st.Saved / time.Duration(st.Won)).Round(time.Millisecond))
+	}
+	fmt.Printf("tail latency saved at p99: %s\n", (before - after).Round(time.Millisecond))
+}
+
+// serve runs the log search as an HTTP service.
+func serve(p *Planner, now time.Time) {
+	const addr = "localhost:7070"
+	s := &server{
+		p: p,
+		done: func(r *http.Request, stats Stats, err error) {
+			fmt.Printf("%s: %d shards, %d canceled, %d failed, %s (%v)\n", r.URL.RawQuery,
+				len(stats.Shards), stats.Canceled(), stats.Failed(), stats.Elapsed.Round(time.Millisecond), err)
+		},
+	}
+	fmt.Printf("Run\ncurl --raw -i \"http://%s/search?q=HTTP_418&last=3h&limit=5\"\n", addr)
+	fmt.Println("and watch the Server-Timing trailer at the end of the response.")
+	fmt.Println("Add &timeout=50ms, or press Ctrl-C during a query without limit, to see the shards get canceled.")
+	fmt.Println(http.ListenAndServe(addr, s.handler()))
+}
+
+type demos []struct {
+	name string
+	desc string
+	fn   func(p *Planner, now time.Time)
+}
+
+func usage(ds demos) {
+	fmt.Println("Usage: logsearch demo")
+	for _, d := range ds {
+		fmt.Printf("\t%s: %s\n", d.name, d.desc)
+	}
+}
+
+func main() {
+	ds := demos{
+		{"route", "full-scan versus routed query", route},
+		{"pool", "shard searches on a dynamic worker pool", pool},
+		{"merge", "time-ordered merge of shard results with a limit", merge},
+		{"hedge", "hedged shard requests to replicas", hedge},
+		{"serve", "log search over HTTP", serve},
+	}
+	if len(os.Args) <= 1 {
+		usage(ds)
+		return
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	now := time.Now().Truncate(time.Hour)
+	p := &Planner{
+		Shards:       newCluster(now, numNodes, shardsPerNode, sliceLen, entriesPerShard),
+		ShardTimeout: 2 * time.Second,
+		Reserve:      100 * time.Millisecond,
+	}
+
+	for _, d := range ds {
+		if d.name == os.Args[1] {
+			d.fn(p, now)
+			return
+		}
+	}
+	usage(ds)
+}
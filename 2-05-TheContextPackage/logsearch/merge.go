@@ -0,0 +1,198 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ShardStream is the result channel of a single shard query.
+// The shard sends its matches in time stamp order
+// and closes C when it is done.
+type ShardStream struct {
+	C <-chan LogEntry
+
+	// From is a lower bound for the time stamps of all entries on C,
+	// usually the start of the shard's time slice.
+	From time.Time
+
+	// cancel stops the shard query.
+	cancel context.CancelFunc
+}
+
+// Streams starts one goroutine per planned shard. Unlike Execute, every shard
+// gets a separate result channel and can be canceled individually.
+// The returned wait function blocks until all shard goroutines have
+// finished, and then returns the query stats.
+//
+// Streams does not use p.Pool: Merge reads from the streams selectively,
+// so a shard search that waits in the pool's queue would block the merge,
+// while the running ones wait for the merge to read their results.
+func (p *Planner) Streams(ctx context.Context, plan Plan) (streams []*ShardStream, wait func() Stats) {
+	stats := Stats{
+		Total:  len(p.Shards),
+		Shards: make([]ShardStats, len(plan.Shards)),
+	}
+	start := time.Now()
+
+	var g errgroup.Group
+	for i, s := range plan.Shards {
+		i, s := i, s
+		sctx, cancel := context.WithCancel(ctx)
+		ch := make(chan LogEntry)
+		streams = append(streams, &ShardStream{C: ch, From: s.Span.From, cancel: cancel})
+		g.Go(func() error {
+			defer cancel()
+			stats.Shards[i] = p.searchShard(sctx, s, plan.Query, ch)
+			close(ch)
+			return nil
+		})
+	}
+
+	return streams, func() Stats {
+		g.Wait()
+		stats.Elapsed = time.Since(start)
+		return stats
+	}
+}
+
+// head is the next entry of a stream that has been received but not yet emitted.
+type head struct {
+	entry  LogEntry
+	stream int
+}
+
+// heads is a min-heap of stream heads, ordered by time stamp.
+type heads []head
+
+func (h heads) Len() int           { return len(h) }
+func (h heads) Less(i, j int) bool { return h[i].entry.Time.Before(h[j].entry.Time) }
+func (h heads) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *heads) Push(x any)        { *h = append(*h, x.(head)) }
+func (h *heads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergeState tracks a single input stream of Merge.
+type mergeState struct {
+	*ShardStream
+	low     time.Time // no entry on C can be earlier than low
+	pending bool      // the stream has no entry in the heap
+	done    bool      // the stream is exhausted or canceled
+}
+
+// Merge performs a k-way merge of the shard streams and emits a single
+// stream of log entries in time stamp order.
+//
+// The output channel is unbuffered, and Merge holds at most one entry per
+// shard. A shard therefore cannot run ahead of a slow consumer: it blocks
+// on sending its next entry until Merge has passed on the previous one.
+//
+// Merge stops after limit entries; a limit of zero or less means no limit.
+// Once the entries already known to precede a shard's next entry are enough
+// to reach the limit, that shard can no longer contribute, and Merge cancels
+// it right away.
+//
+// When Merge stops, because all streams are exhausted, the limit is reached,
+// or ctx is canceled, it cancels all remaining streams before closing the
+// output channel. No shard goroutine remains blocked on a send.
+func Merge(ctx context.Context, limit int, streams []*ShardStream) <-chan LogEntry {
+	out := make(chan LogEntry)
+
+	go func() {
+		states := make([]*mergeState, len(streams))
+		for i, s := range streams {
+			states[i] = &mergeState{ShardStream: s, low: s.From, pending: true}
+		}
+		defer func() {
+			for _, st := range states {
+				st.cancel()
+			}
+			close(out)
+		}()
+
+		h := &heads{}
+		emitted := 0
+
+		// needed returns the index of a stream that may still deliver an entry
+		// that is earlier than the earliest entry in the heap, or -1 if there is none.
+		needed := func() int {
+			for i, st := range states {
+				if st.pending && !st.done && (h.Len() == 0 || st.low.Before((*h)[0].entry.Time)) {
+					return i
+				}
+			}
+			return -1
+		}
+
+		for {
+			// Receive from every stream that might deliver an earlier entry
+			// than the current minimum.
+			for i := needed(); i >= 0; i = needed() {
+				st := states[i]
+				select {
+				case <-ctx.Done():
+					return
+				case e, ok := <-st.C:
+					if !ok {
+						st.done = true
+						continue
+					}
+					st.low = e.Time
+					st.pending = false
+					heap.Push(h, head{entry: e, stream: i})
+				}
+			}
+			if h.Len() == 0 {
+				return
+			}
+
+			next := heap.Pop(h).(head)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- next.entry:
+			}
+			emitted++
+			states[next.stream].pending = true
+
+			if limit > 0 {
+				if emitted >= limit {
+					return
+				}
+				prune(states, *h, limit-emitted)
+			}
+		}
+	}()
+
+	return out
+}
+
+// prune cancels all streams that cannot contribute to the
+// remaining number of entries anymore. A stream cannot contribute
+// if at least remaining entries in the heap precede its lower bound.
+func prune(states []*mergeState, h heads, remaining int) {
+	if len(h) < remaining {
+		return
+	}
+	for _, st := range states {
+		if st.done {
+			continue
+		}
+		earlier := 0
+		for _, hd := range h {
+			if hd.entry.Time.Before(st.low) {
+				earlier++
+			}
+		}
+		if earlier >= remaining {
+			st.cancel()
+			st.done = true
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStreams creates n streams of size random entries each. Every stream
+// covers the same time range, so the merge has to interleave them.
+// The returned WaitGroup is done when all sending goroutines have ended.
+func testStreams(ctx context.Context, n, size int) ([]*ShardStream, []LogEntry, *sync.WaitGroup) {
+	base := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	var all []LogEntry
+	var wg sync.WaitGroup
+	streams := make([]*ShardStream, n)
+	for i := range streams {
+		entries := make([]LogEntry, size)
+		for j := range entries {
+			entries[j] = LogEntry{Time: base.Add(time.Duration(rand.Intn(3600)) * time.Second), Shard: i}
+		}
+		sort.Slice(entries, func(a, b int) bool { return entries[a].Time.Before(entries[b].Time) })
+		all = append(all, entries...)
+
+		sctx, cancel := context.WithCancel(ctx)
+		ch := make(chan LogEntry)
+		streams[i] = &ShardStream{C: ch, From: base, cancel: cancel}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(ch)
+			for _, e := range entries {
+				select {
+				case <-sctx.Done():
+					return
+				case ch <- e:
+				}
+			}
+		}()
+	}
+	sort.SliceStable(all, func(a, b int) bool { return all[a].Time.Before(all[b].Time) })
+	return streams, all, &wg
+}
+
+func TestMergeOrdersEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams int
+		size    int
+		limit   int
+	}{
+		{"no limit", 5, 100, 0},
+		{"limit below size", 5, 100, 17},
+		{"limit above size", 3, 10, 100},
+		{"empty streams", 4, 0, 5},
+		{"single stream", 1, 50, 0},
+	}
+	for _, tt := range tests {
+		streams, all, wg := testStreams(context.Background(), tt.streams, tt.size)
+		want := len(all)
+		if tt.limit > 0 && tt.limit < want {
+			want = tt.limit
+		}
+
+		var got []LogEntry
+		for e := range Merge(context.Background(), tt.limit, streams) {
+			got = append(got, e)
+		}
+		wg.Wait()
+
+		if len(got) != want {
+			t.Errorf("%s: got %d entries, want %d", tt.name, len(got), want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Time.Equal(all[i].Time) {
+				t.Errorf("%s: entry %d has time %s, want %s", tt.name, i, got[i].Time, all[i].Time)
+				break
+			}
+		}
+	}
+}
+
+func TestMergeStopsShards(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streams, _, wg := testStreams(ctx, 8, 1000)
+	out := Merge(ctx, 0, streams)
+
+	// Read a few entries, then give up.
+	for i := 0; i < 5; i++ {
+		<-out
+	}
+	cancel()
+
+	// The merge must close its output channel...
+	for range out {
+	}
+	// ...and every shard goroutine must end.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shard goroutines still running after cancel")
+	}
+
+	// Allow the merge goroutine to finish its deferred cleanup.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines leaked", n-before)
+	}
+}
+
+func TestMergeWithPlanner(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := &Planner{Shards: newCluster(now, 3, 4, time.Hour, 500)}
+
+	streams, wait := p.Streams(context.Background(), p.Plan(Query{Match: Contains("HTTP_418")}))
+	var last time.Time
+	n := 0
+	for e := range Merge(context.Background(), 20, streams) {
+		if e.Time.Before(last) {
+			t.Errorf("entry %d at %s is earlier than its predecessor at %s", n, e.Time, last)
+		}
+		last = e.Time
+		n++
+	}
+	if n != 20 {
+		t.Errorf("got %d entries, want 20", n)
+	}
+
+	stats := wait()
+	if stats.Canceled() == 0 {
+		t.Error("no shard was canceled after the limit was reached")
+	}
+}
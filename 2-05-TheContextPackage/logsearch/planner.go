@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool"
+	"golang.org/x/sync/errgroup"
+)
+
+// Planner routes queries to the shards whose time range overlaps
+// the query's time span.
+type Planner struct {
+	Shards []*Shard
+
+	// ShardTimeout caps the time a single shard may spend on a query.
+	// Zero means no cap other than the parent context's deadline.
+	ShardTimeout time.Duration
+
+	// Reserve is subtracted from the parent context's deadline,
+	// to leave the caller some time for processing the shard results
+	// before the parent context expires. For short deadlines, the
+	// reserve shrinks to half of the time left.
+	Reserve time.Duration
+
+	// Pool, if not nil, runs the shard searches of Execute, instead of
+	// one goroutine per shard. The pool limits the number of concurrent
+	// shard searches, and adds and retires workers with the load.
+	Pool *workerpool.Pool
+}
+
+// Plan is the list of shards that a query is routed to.
+type Plan struct {
+	Query  Query
+	Shards []*Shard
+}
+
+// Plan returns the shards that can contain entries matching q.
+// A query without a time span is routed to all shards.
+func (p *Planner) Plan(q Query) Plan {
+	plan := Plan{Query: q}
+	for _, s := range p.Shards {
+		if s.Span.Overlaps(q.Span) {
+			plan.Shards = append(plan.Shards, s)
+		}
+	}
+	return plan
+}
+
+// shardContext derives the context for a single shard query from ctx.
+// The shard deadline is the earlier of ShardTimeout from now
+// and the parent deadline minus Reserve.
+func (p *Planner) shardContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	if p.ShardTimeout > 0 {
+		deadline = time.Now().Add(p.ShardTimeout)
+	}
+	if parent, ok := ctx.Deadline(); ok {
+		reserve := p.Reserve
+		if half := time.Until(parent) / 2; reserve > half {
+			reserve = half
+		}
+		if d := parent.Add(-reserve); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// ShardStats records the cost of a query on a single shard.
+type ShardStats struct {
+	Shard   *Shard
+	Scanned int
+	Elapsed time.Duration
+	Err     error
+}
+
+// Stats summarizes the fan-out cost of a query.
+type Stats struct {
+	Total   int // number of shards in the cluster
+	Shards  []ShardStats
+	Elapsed time.Duration
+}
+
+// Scanned returns the number of entries scanned across all shards.
+func (s Stats) Scanned() int {
+	n := 0
+	for _, st := range s.Shards {
+		n += st.Scanned
+	}
+	return n
+}
+
+// ShardTime returns the time spent across all shards.
+// This is the work the cluster had to do, as opposed to
+// the wall time that the caller had to wait.
+func (s Stats) ShardTime() time.Duration {
+	var d time.Duration
+	for _, st := range s.Shards {
+		d += st.Elapsed
+	}
+	return d
+}
+
+// Failed returns the number of shards that did not complete the query
+// for any reason other than being canceled.
+func (s Stats) Failed() int {
+	n := 0
+	for _, st := range s.Shards {
+		if st.Err != nil && !errors.Is(st.Err, context.Canceled) {
+			n++
+		}
+	}
+	return n
+}
+
+// Canceled returns the number of shards that were canceled
+// before they completed the query.
+func (s Stats) Canceled() int {
+	n := 0
+	for _, st := range s.Shards {
+		if errors.Is(st.Err, context.Canceled) {
+			n++
+		}
+	}
+	return n
+}
+
+// searchShard runs q on shard s with a context derived by shardContext.
+func (p *Planner) searchShard(ctx context.Context, s *Shard, q Query, res chan<- LogEntry) ShardStats {
+	sctx, cancel := p.shardContext(ctx)
+	defer cancel()
+	start := time.Now()
+	n, err := s.search(sctx, q, res)
+	return ShardStats{Shard: s, Scanned: n, Elapsed: time.Since(start), Err: err}
+}
+
+// Execute runs the plan concurrently on every planned shard and sends
+// the matching entries to res. Execute blocks until all shards are done,
+// then closes res.
+// A shard that exceeds its deadline does not fail the query; the error
+// is recorded in the shard's stats instead.
+func (p *Planner) Execute(ctx context.Context, plan Plan, res chan<- LogEntry) Stats {
+	stats := Stats{
+		Total:  len(p.Shards),
+		Shards: make([]ShardStats, len(plan.Shards)),
+	}
+	start := time.Now()
+
+	if p.Pool != nil {
+		p.executeOnPool(ctx, plan, res, stats.Shards)
+	} else {
+		var g errgroup.Group
+		for i, s := range plan.Shards {
+			i, s := i, s
+			g.Go(func() error {
+				// Each goroutine owns one element of the stats slice,
+				// hence no locking is required.
+				stats.Shards[i] = p.searchShard(ctx, s, plan.Query, res)
+				return nil
+			})
+		}
+		g.Wait() // error check omitted because no goroutine returns an error
+	}
+	close(res)
+
+	stats.Elapsed = time.Since(start)
+	return stats
+}
+
+// executeOnPool runs the shard searches of plan on p.Pool, and waits
+// for them. A shard that cannot be submitted, because ctx is canceled
+// or the pool is closed, gets the error in its stats.
+func (p *Planner) executeOnPool(ctx context.Context, plan Plan, res chan<- LogEntry, stats []ShardStats) {
+	var wg sync.WaitGroup
+	for i, s := range plan.Shards {
+		i, s := i, s
+		wg.Add(1)
+		err := p.Pool.Submit(ctx, func() {
+			defer wg.Done()
+			stats[i] = p.searchShard(ctx, s, plan.Query, res)
+		})
+		if err != nil {
+			wg.Done()
+			stats[i] = ShardStats{Shard: s, Err: err}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool"
+)
+
+func TestPlanRoutesByTimeSpan(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := &Planner{Shards: newCluster(now, 2, 3, time.Hour, 10)}
+
+	tests := []struct {
+		name string
+		span TimeRange
+		want int
+	}{
+		{"no span", TimeRange{}, 6},
+		{"last hour", Last(time.Hour, now), 1},
+		{"straddling two slices", TimeRange{From: now.Add(-90 * time.Minute), To: now.Add(-30 * time.Minute)}, 2},
+		{"open end", TimeRange{From: now.Add(-150 * time.Minute)}, 3},
+		{"before all data", TimeRange{To: now.Add(-6 * time.Hour)}, 0},
+		{"after all data", TimeRange{From: now}, 0},
+	}
+	for _, tt := range tests {
+		plan := p.Plan(Query{Span: tt.span})
+		if len(plan.Shards) != tt.want {
+			t.Errorf("%s: got %d shards, want %d", tt.name, len(plan.Shards), tt.want)
+		}
+		for _, s := range plan.Shards {
+			if !s.Span.Overlaps(tt.span) {
+				t.Errorf("%s: %s with span %s does not overlap %s", tt.name, s, s.Span, tt.span)
+			}
+		}
+	}
+}
+
+func TestShardContextEndsBeforeParent(t *testing.T) {
+	p := &Planner{ShardTimeout: time.Hour, Reserve: 100 * time.Millisecond}
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ctx, cancelShard := p.shardContext(parent)
+	defer cancelShard()
+
+	pd, _ := parent.Deadline()
+	sd, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("shard context has no deadline")
+	}
+	if got := pd.Sub(sd); got < p.Reserve {
+		t.Errorf("shard deadline is %s before parent deadline, want at least %s", got, p.Reserve)
+	}
+}
+
+func TestExecuteOnPool(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := &Planner{Shards: newCluster(now, 2, 4, time.Hour, 200)}
+	q := Query{Match: Contains("HTTP_418")}
+
+	count := func() (int, Stats) {
+		res := make(chan LogEntry)
+		n := make(chan int)
+		go func() {
+			i := 0
+			for range res {
+				i++
+			}
+			n <- i
+		}()
+		stats := p.Execute(context.Background(), p.Plan(q), res)
+		return <-n, stats
+	}
+
+	want, _ := count()
+	p.Pool = workerpool.New(workerpool.Options{Max: 3})
+	defer p.Pool.Close()
+	got, stats := count()
+	if got != want || stats.Scanned() != 8*200 || stats.Failed() != 0 {
+		t.Errorf("got %d matches in %d entries, want %d in %d", got, stats.Scanned(), want, 8*200)
+	}
+	if m := p.Pool.Metrics(); m.PeakWorkers > 3 || m.Completed != 8 {
+		t.Errorf("unexpected pool metrics %+v", m)
+	}
+
+	// A closed pool fails all shards.
+	p.Pool.Close()
+	if _, stats := count(); stats.Failed() != 8 {
+		t.Errorf("%d of 8 shards failed on a closed pool", stats.Failed())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// TimeRange is the half-open time interval [From, To).
+// A zero From or To leaves that end of the range open,
+// hence the zero TimeRange covers all of time.
+type TimeRange struct {
+	From, To time.Time
+}
+
+// Last returns the TimeRange that covers the duration d up to now.
+func Last(d time.Duration, now time.Time) TimeRange {
+	return TimeRange{From: now.Add(-d), To: now}
+}
+
+// IsZero reports whether r is unbounded on both ends.
+func (r TimeRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// Contains reports whether t lies within r.
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) &&
+		(r.To.IsZero() || t.Before(r.To))
+}
+
+// Overlaps reports whether r and o have at least one instant in common.
+func (r TimeRange) Overlaps(o TimeRange) bool {
+	return (r.From.IsZero() || o.To.IsZero() || r.From.Before(o.To)) &&
+		(o.From.IsZero() || r.To.IsZero() || o.From.Before(r.To))
+}
+
+func (r TimeRange) String() string {
+	if r.IsZero() {
+		return "all time"
+	}
+	const layout = "Jan 2 15:04"
+	from, to := "-inf", "+inf"
+	if !r.From.IsZero() {
+		from = r.From.Format(layout)
+	}
+	if !r.To.IsZero() {
+		to = r.To.Format(layout)
+	}
+	return "[" + from + ", " + to + ")"
+}
+
+// Predicate decides whether a log entry matches a query.
+type Predicate func(LogEntry) bool
+
+// Contains returns a Predicate that matches all entries
+// whose message contains s.
+func Contains(s string) Predicate {
+	return func(e LogEntry) bool {
+		return strings.Contains(e.Msg, s)
+	}
+}
+
+// Query selects log entries within a time span.
+// A query without a time span must be executed across all nodes and shards.
+type Query struct {
+	Span  TimeRange
+	Match Predicate
+}
+
+// matches reports whether e satisfies both the time span and the predicate of q.
+func (q Query) matches(e LogEntry) bool {
+	return q.Span.Contains(e.Time) && (q.Match == nil || q.Match(e))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// server exposes the log search as an HTTP endpoint.
+//
+// The query context is derived from the request context. If the client
+// disconnects, or the optional timeout expires, the context is canceled,
+// and so are all shard goroutines of the query.
+type server struct {
+	p *Planner
+
+	// done, if not nil, is called after each search request
+	// with the query stats and the reason the query ended, if any.
+	done func(r *http.Request, stats Stats, err error)
+}
+
+// parseQuery reads the query from the URL parameters:
+//
+//	q        the text to search for (required)
+//	from, to the time span, as RFC 3339 time stamps
+//	last     the time span as a duration up to now, e.g. "2h"; overrides from and to
+//	limit    the maximum number of results; 0 or missing means no limit
+//	timeout  the maximum duration of the query, e.g. "500ms"
+func parseQuery(r *http.Request) (q Query, limit int, timeout time.Duration, err error) {
+	v := r.URL.Query()
+
+	text := v.Get("q")
+	if text == "" {
+		return q, 0, 0, fmt.Errorf("missing parameter q")
+	}
+	q.Match = Contains(text)
+
+	if s := v.Get("from"); s != "" {
+		if q.Span.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, 0, 0, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.Span.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, 0, 0, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if s := v.Get("last"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return q, 0, 0, fmt.Errorf("invalid last: %w", err)
+		}
+		q.Span = Last(d, time.Now())
+	}
+	if s := v.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			return q, 0, 0, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	if s := v.Get("timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return q, 0, 0, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return q, limit, timeout, nil
+}
+
+// search streams the matching log entries in time stamp order as
+// newline-delimited JSON. After the last entry, the Server-Timing
+// trailer reports how long each shard took and how it ended.
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	q, limit, timeout, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A client disconnect cancels the request context,
+	// and with it the query context.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	defer cancel()
+
+	// Trailers must be announced before the body is written.
+	w.Header().Set("Trailer", "Server-Timing")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	streams, wait := s.p.Streams(ctx, s.p.Plan(q))
+	for e := range Merge(ctx, limit, streams) {
+		if err := enc.Encode(e); err != nil {
+			// The client is gone. Cancel the query, and let Merge
+			// close the channel to end the loop.
+			cancel()
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	stats := wait()
+
+	w.Header().Set("Server-Timing", serverTiming(stats))
+	if s.done != nil {
+		s.done(r, stats, ctx.Err())
+	}
+}
+
+// serverTiming formats the per-shard timing as a Server-Timing header value.
+func serverTiming(stats Stats) string {
+	metrics := make([]string, 0, len(stats.Shards)+1)
+	metrics = append(metrics, fmt.Sprintf("total;dur=%.1f", ms(stats.Elapsed)))
+	for _, st := range stats.Shards {
+		desc := "done"
+		if st.Err != nil {
+			desc = st.Err.Error()
+		}
+		metrics = append(metrics, fmt.Sprintf("node%d-shard%d;dur=%.1f;desc=%q",
+			st.Shard.Node, st.Shard.ID, ms(st.Elapsed), desc))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.search)
+	return mux
+}
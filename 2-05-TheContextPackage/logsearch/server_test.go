@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type result struct {
+	stats Stats
+	err   error
+}
+
+func testServer(t *testing.T) (*httptest.Server, <-chan result) {
+	now := time.Now().Truncate(time.Hour)
+	done := make(chan result, 1)
+	s := &server{
+		p: &Planner{Shards: newCluster(now, 2, 4, time.Hour, 2000)},
+		done: func(r *http.Request, stats Stats, err error) {
+			done <- result{stats, err}
+		},
+	}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return ts, done
+}
+
+func TestSearchStreamsOrderedResults(t *testing.T) {
+	ts, done := testServer(t)
+
+	resp, err := http.Get(ts.URL + "/search?q=HTTP_418&limit=25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got content type %q", ct)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	var last time.Time
+	n := 0
+	for {
+		var e LogEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if e.Time.Before(last) {
+			t.Errorf("result %d is out of order", n)
+		}
+		last = e.Time
+		n++
+	}
+	if n != 25 {
+		t.Errorf("got %d results, want 25", n)
+	}
+
+	// Trailers are available after the body has been read.
+	timing := resp.Trailer.Get("Server-Timing")
+	if !strings.HasPrefix(timing, "total;dur=") || strings.Count(timing, "shard") != 8 {
+		t.Errorf("unexpected Server-Timing trailer %q", timing)
+	}
+	<-done
+}
+
+func TestSearchBadRequest(t *testing.T) {
+	ts, _ := testServer(t)
+	for _, params := range []string{"", "q=x&last=yesterday", "q=x&limit=ten", "q=x&timeout=soon", "q=x&from=today"} {
+		resp, err := http.Get(ts.URL + "/search?" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", params, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	ts, done := testServer(t)
+
+	resp, err := http.Get(ts.URL + "/search?q=pid&timeout=20ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	res := <-done
+	if res.stats.Failed() != len(res.stats.Shards) {
+		t.Errorf("%d of %d shards failed, want all", res.stats.Failed(), len(res.stats.Shards))
+	}
+	if !strings.Contains(resp.Trailer.Get("Server-Timing"), "deadline exceeded") {
+		t.Errorf("trailer does not report the timeout: %q", resp.Trailer.Get("Server-Timing"))
+	}
+}
+
+func TestClientDisconnectCancelsShards(t *testing.T) {
+	ts, done := testServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/search?q=e", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the first result, then hang up.
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case res := <-done:
+		if res.err == nil {
+			t.Error("query context was not canceled")
+		}
+		if res.stats.Canceled() == 0 {
+			t.Error("no shard was canceled")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("query still running after the client disconnected")
+	}
+}
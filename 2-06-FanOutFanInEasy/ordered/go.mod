@@ -0,0 +1,7 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/2-06-FanOutFanInEasy/ordered
+
+go 1.19
+
+require github.com/AppliedGoCourses/ConcurrencyDeepDive/chans v0.0.0
+
+replace github.com/AppliedGoCourses/ConcurrencyDeepDive/chans => ../../chans
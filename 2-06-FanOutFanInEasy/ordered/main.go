@@ -0,0 +1,104 @@
+package main
+
+/* Ordered fan-out and fan-in.
+
+In the trivial fan-out/fan-in, the results arrive in whatever order
+the workers finish. Run the scattergather example twice, and the
+output differs each time.
+
+chans.OrderedMap fans out the work to N workers as well, but passes
+on the results in the order of the input. A result that is ready early
+waits in a reorder buffer until all results before it have been passed
+on. The buffer is bounded: if the oldest item takes long, OrderedMap
+stops taking new work once the buffer is full, rather than collecting
+an unlimited number of results.
+
+Try
+
+	go run .
+
+and compare the unordered and the ordered output.
+*/
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/chans"
+)
+
+// Each work item is a whole file to scan.
+type goFile struct {
+	name    string
+	content string
+}
+
+// scan takes a random amount of time, like a real scanner
+// that takes longer for larger files.
+func scan(f goFile) string {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+	return fmt.Sprintf("%s scanned", f.name)
+}
+
+// files sends the files to a channel.
+func files(ctx context.Context, si []goFile) <-chan goFile {
+	ch := make(chan goFile)
+	go func() {
+		defer close(ch)
+		for _, f := range si {
+			select {
+			case ch <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func main() {
+	si := []goFile{
+		{name: "main.go", content: "package main\n\nfunc main() {}"},
+		{name: "utils.go", content: "package utils\n\nfunc Util() {}"},
+		{name: "helper.go", content: "package helper\n\nfunc Help() {}"},
+		{name: "misc.go", content: "package misc\n\nfunc Misc() {}"},
+		{name: "various.go", content: "package various\n\nfunc Various() {}"},
+		{name: "more.go", content: "package more\n\nfunc More() {}"},
+	}
+	const numWorkers = 3
+	const window = 4
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Unordered: three workers share the input channel
+	// and send their results to a shared result channel.
+	fmt.Println("Unordered:")
+	in := files(ctx, si)
+	res := make(chan string)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			for f := range in {
+				res <- scan(f)
+			}
+		}()
+	}
+	for range si {
+		fmt.Println("  ", <-res)
+	}
+
+	// Ordered: the same work, the same number of workers,
+	// but the results come in the order of the input.
+	fmt.Println("Ordered:")
+	start := time.Now()
+	for r := range chans.OrderedMap(ctx, files(ctx, si), numWorkers, window, scan) {
+		fmt.Println("  ", r)
+	}
+	fmt.Println("done in", time.Since(start).Round(time.Millisecond))
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
@@ -0,0 +1,9 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/2-07-FanOutFanInUniversal/declarative
+
+go 1.19
+
+require github.com/AppliedGoCourses/ConcurrencyDeepDive/pipeline v0.0.0
+
+require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+
+replace github.com/AppliedGoCourses/ConcurrencyDeepDive/pipeline => ../../pipeline
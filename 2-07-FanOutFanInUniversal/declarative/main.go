@@ -0,0 +1,102 @@
+package main
+
+/*
+The pipeline of 2-07, declared instead of wired by hand.
+
+In 2-07, main creates the channels, starts the producer, calls fanOut,
+starts the scanner goroutines, calls fanIn, and collects the results.
+With the pipeline package, each stage is declared with its function,
+its number of workers, and the size of its input queue:
+
+	files -> broadcast -> sql scan    (2 workers) -> merge -> print
+	                   -> timing scan (1 worker)  ->
+	                   -> auth scan   (4 workers) ->
+
+The pipeline package starts the goroutines, connects the stages, and
+cancels all stages when one of them fails. After the run, it prints the
+statistics of each stage. The timing scan is the slowest one: it has
+only one worker, hence it is busy all the time, and its input queue is
+full. The broadcast has to wait for it, and so do all other stages.
+
+Try
+
+	go run .
+	go run . -timing-workers 4
+
+and compare the statistics.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/pipeline"
+)
+
+type goFile struct {
+	name string
+	data string
+}
+
+func mockScan() string {
+	<-time.After(time.Duration(rand.Intn(20)) * time.Millisecond)
+	if rand.Intn(100) > 90 {
+		return "ALERT - vulnerability found"
+	}
+	return "OK"
+}
+
+// scanner returns a stage function for the scan with the given name.
+func scanner(name string) func(context.Context, goFile) (string, error) {
+	return func(ctx context.Context, f goFile) (string, error) {
+		return fmt.Sprintf("%s scan: %s scanned, result: %s", name, f.name, mockScan()), nil
+	}
+}
+
+func main() {
+	timingWorkers := flag.Int("timing-workers", 1, "number of workers of the timing scan")
+	numFiles := flag.Int("files", 100, "number of files to scan")
+	flag.Parse()
+
+	p := pipeline.New()
+
+	files := pipeline.Source(p, "files", func(ctx context.Context, emit func(goFile) error) error {
+		for i := 0; i < *numFiles; i++ {
+			name := fmt.Sprintf("file%03d.go", i)
+			if err := emit(goFile{name: name, data: "package main"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	copies := pipeline.Broadcast(p, "broadcast", files, pipeline.Options{Buffer: 10}, 3)
+	sql := pipeline.Map(p, "sql", copies[0], pipeline.Options{Workers: 2, Buffer: 10}, scanner("SQL injection"))
+	timing := pipeline.Map(p, "timing", copies[1], pipeline.Options{Workers: *timingWorkers, Buffer: 10}, scanner("Timing exploits"))
+	auth := pipeline.Map(p, "auth", copies[2], pipeline.Options{Workers: 4, Buffer: 10}, scanner("Authentication"))
+	alerts := 0
+	results := pipeline.Merge(p, sql, timing, auth)
+	pipeline.Sink(p, "collect", results, pipeline.Options{Buffer: 10}, func(ctx context.Context, r string) error {
+		if !strings.HasSuffix(r, "OK") {
+			alerts++
+			fmt.Println(r)
+		}
+		return nil
+	})
+
+	start := time.Now()
+	if err := p.Run(context.Background()); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("\n%d alerts, done in %s\n\n", alerts, time.Since(start).Round(time.Millisecond))
+	for _, s := range p.Stats() {
+		fmt.Println(s)
+	}
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
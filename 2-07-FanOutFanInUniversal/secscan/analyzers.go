@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// source is a parsed Go file. The syntax tree is only read by
+// the analyzers, hence multiple analyzers can share it concurrently.
+type source struct {
+	name string
+	data string
+	fset *token.FileSet
+	file *ast.File
+}
+
+// parse parses the contents of a Go file.
+func parse(f goFile) (*source, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, f.name, f.data, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	return &source{name: f.name, data: f.data, fset: fset, file: file}, nil
+}
+
+func (s *source) finding(rule Rule, n ast.Node, format string, args ...any) Finding {
+	pos := s.fset.Position(n.Pos())
+	return Finding{
+		Rule:     rule.ID,
+		Severity: rule.Severity,
+		File:     s.name,
+		Pos:      Position{Line: pos.Line, Column: pos.Column},
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// funcs calls fn for every function declaration and function literal in s.
+// Function literals have an empty name. If outermost is true, fn is not
+// called for function literals within functions, as they are part of the
+// enclosing function's body.
+func (s *source) funcs(outermost bool, fn func(ftype *ast.FuncType, body *ast.BlockStmt, name string)) {
+	ast.Inspect(s.file, func(n ast.Node) bool {
+		switch f := n.(type) {
+		case *ast.FuncDecl:
+			if f.Body != nil {
+				fn(f.Type, f.Body, f.Name.Name)
+			}
+			return !outermost
+		case *ast.FuncLit:
+			fn(f.Type, f.Body, "")
+			return !outermost
+		}
+		return true
+	})
+}
+
+var ruleSQLConcat = Rule{"SQL001", "SQLStringConcatenation", "SQL query built from non-constant strings", SeverityError}
+
+// sqlMethods are the database/sql methods that take a query string.
+// The value is the index of the query argument.
+var sqlMethods = map[string]int{
+	"Query":           0,
+	"QueryRow":        0,
+	"Exec":            0,
+	"Prepare":         0,
+	"QueryContext":    1,
+	"QueryRowContext": 1,
+	"ExecContext":     1,
+	"PrepareContext":  1,
+}
+
+// sqlInjection reports SQL queries that are built by string concatenation
+// or fmt.Sprintf and passed to one of the sqlMethods. A query that is
+// assigned to a variable first is tracked within the same function.
+func sqlInjection(s *source) []Finding {
+	var findings []Finding
+	s.funcs(true, func(_ *ast.FuncType, body *ast.BlockStmt, _ string) {
+		built := builtStrings(body)
+		ast.Inspect(body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			i, ok := sqlMethods[sel.Sel.Name]
+			if !ok || i >= len(call.Args) {
+				return true
+			}
+			arg := call.Args[i]
+			if isBuiltString(arg) {
+				findings = append(findings, s.finding(ruleSQLConcat, call,
+					"query passed to %s is built from non-constant strings; use query parameters instead", sel.Sel.Name))
+			} else if id, ok := arg.(*ast.Ident); ok && built[id.Name] {
+				findings = append(findings, s.finding(ruleSQLConcat, call,
+					"query %s passed to %s is built from non-constant strings; use query parameters instead", id.Name, sel.Sel.Name))
+			}
+			return true
+		})
+	})
+	return findings
+}
+
+// builtStrings returns the names of all variables in body that are assigned
+// a string built by concatenation or fmt.Sprintf.
+func builtStrings(body *ast.BlockStmt) map[string]bool {
+	built := map[string]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok || len(as.Lhs) != len(as.Rhs) {
+			return true
+		}
+		for i, lhs := range as.Lhs {
+			id, ok := lhs.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			rhs := as.Rhs[i]
+			switch {
+			case as.Tok == token.ADD_ASSIGN && !isStringLit(rhs):
+				built[id.Name] = true
+			case isBuiltString(rhs):
+				built[id.Name] = true
+			}
+		}
+		return true
+	})
+	return built
+}
+
+// isBuiltString reports whether e concatenates a string literal with a
+// non-constant expression, or is a call to fmt.Sprintf.
+func isBuiltString(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return isBuiltString(e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return false
+		}
+		return !isStringLit(e.X) || !isStringLit(e.Y)
+	case *ast.CallExpr:
+		if sel, ok := e.Fun.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "fmt" && sel.Sel.Name == "Sprintf" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isStringLit reports whether e is a string literal, or a concatenation
+// of string literals.
+func isStringLit(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return e.Kind == token.STRING
+	case *ast.ParenExpr:
+		return isStringLit(e.X)
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && isStringLit(e.X) && isStringLit(e.Y)
+	}
+	return false
+}
+
+// words splits an identifier like "apiKeyHMAC_v2" into
+// its lower-case words "api", "key", "hmac", "v2".
+func words(name string) []string {
+	var ws []string
+	var w []rune
+	rs := []rune(name)
+	for i, r := range rs {
+		upper := unicode.IsUpper(r)
+		// A word ends before an upper-case letter that follows a lower-case one,
+		// or that starts a new word after an acronym ("HMACKey": "hmac", "key").
+		boundary := upper && i > 0 && (unicode.IsLower(rs[i-1]) ||
+			i+1 < len(rs) && unicode.IsUpper(rs[i-1]) && unicode.IsLower(rs[i+1]))
+		if r == '_' || boundary {
+			if len(w) > 0 {
+				ws = append(ws, string(w))
+			}
+			w = w[:0]
+			if r == '_' {
+				continue
+			}
+		}
+		w = append(w, unicode.ToLower(r))
+	}
+	if len(w) > 0 {
+		ws = append(ws, string(w))
+	}
+	return ws
+}
+
+// hasWord reports whether one of the words of name
+// starts with one of the given prefixes.
+func hasWord(name string, prefixes []string) bool {
+	for _, w := range words(name) {
+		for _, p := range prefixes {
+			if strings.HasPrefix(w, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exprName returns the name of the identifier, field, method,
+// or function that e refers to, or "" if there is none.
+func exprName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.CallExpr:
+		return exprName(e.Fun)
+	case *ast.IndexExpr:
+		return exprName(e.X)
+	case *ast.SliceExpr:
+		return exprName(e.X)
+	case *ast.StarExpr:
+		return exprName(e.X)
+	case *ast.ParenExpr:
+		return exprName(e.X)
+	}
+	return ""
+}
+
+var ruleTimingEqual = Rule{"TIM001", "NonConstantTimeComparison", "Secret compared in non-constant time", SeverityWarning}
+
+// secretWords are words that indicate a secret value.
+// "sig" and "pass" would also match "signal" and "passenger",
+// hence the complete words are listed.
+var secretWords = []string{
+	"token", "tokens", "secret", "secrets", "hmac", "mac", "sig", "signature",
+	"password", "passwd", "pwd", "apikey", "digest", "otp", "nonce",
+}
+
+// secretName returns the name of e if it looks like a secret, or "" otherwise.
+func secretName(e ast.Expr) string {
+	name := exprName(e)
+	ws := words(name)
+	for i, w := range ws {
+		for _, s := range secretWords {
+			if w == s || i > 0 && ws[i-1]+w == s {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// isEmptyCheck reports whether e is nil or an empty string literal.
+// Comparing a secret against these leaks nothing.
+func isEmptyCheck(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name == "nil"
+	case *ast.BasicLit:
+		return e.Kind == token.STRING && (e.Value == `""` || e.Value == "``")
+	}
+	return false
+}
+
+// timingExploits reports comparisons of secrets with == or != and
+// bytes.Equal. These return as soon as the first byte differs, so the
+// response time reveals how much of a guessed secret is correct.
+func timingExploits(s *source) []Finding {
+	var findings []Finding
+	ast.Inspect(s.file, func(n ast.Node) bool {
+		switch e := n.(type) {
+		case *ast.BinaryExpr:
+			if e.Op != token.EQL && e.Op != token.NEQ {
+				return true
+			}
+			if isEmptyCheck(e.X) || isEmptyCheck(e.Y) {
+				return true
+			}
+			name := secretName(e.X)
+			if name == "" {
+				name = secretName(e.Y)
+			}
+			if name != "" {
+				findings = append(findings, s.finding(ruleTimingEqual, e,
+					"%s is compared with %s; use subtle.ConstantTimeCompare or hmac.Equal instead", name, e.Op))
+			}
+		case *ast.CallExpr:
+			if !isSelector(e.Fun, "bytes", "Equal") || len(e.Args) != 2 {
+				return true
+			}
+			name := secretName(e.Args[0])
+			if name == "" {
+				name = secretName(e.Args[1])
+			}
+			if name != "" {
+				findings = append(findings, s.finding(ruleTimingEqual, e,
+					"%s is compared with bytes.Equal; use subtle.ConstantTimeCompare or hmac.Equal instead", name))
+			}
+		}
+		return true
+	})
+	return findings
+}
+
+var ruleMissingAuth = Rule{"AUTH001", "HandlerWithoutAuth", "HTTP handler without authentication check", SeverityWarning}
+
+// authWords are word prefixes of functions and methods that check
+// authentication or authorization, like checkAuth or isAllowed.
+var authWords = []string{"auth", "login", "session", "verify", "permission", "allow"}
+
+// publicWords are word prefixes of handlers that are public by design.
+var publicWords = []string{"health", "ping", "ready", "live", "login", "public", "static", "metrics"}
+
+// isHandler reports whether ft has the signature of an http.HandlerFunc.
+func isHandler(ft *ast.FuncType) bool {
+	if ft.Params == nil || ft.Params.NumFields() != 2 {
+		return false
+	}
+	var types []ast.Expr
+	for _, p := range ft.Params.List {
+		n := len(p.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, p.Type)
+		}
+	}
+	return isSelector(types[0], "http", "ResponseWriter") && isPointerTo(types[1], "http", "Request")
+}
+
+func isSelector(e ast.Expr, pkg, name string) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Name == pkg && sel.Sel.Name == name
+}
+
+func isPointerTo(e ast.Expr, pkg, name string) bool {
+	star, ok := e.(*ast.StarExpr)
+	return ok && isSelector(star.X, pkg, name)
+}
+
+// checksAuth reports whether body calls a function whose name suggests
+// an authentication check, or reads the Authorization header.
+func checksAuth(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch e := n.(type) {
+		case *ast.CallExpr:
+			if hasWord(exprName(e.Fun), authWords) {
+				found = true
+			}
+		case *ast.BasicLit:
+			if e.Kind == token.STRING && strings.EqualFold(strings.Trim(e.Value, "\"`"), "Authorization") {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// wrappedHandlers returns the names of all functions in s that are passed
+// to a function whose name suggests an authentication middleware,
+// like requireAuth(adminHandler), and the function literals that are
+// passed to one, like requireAuth(func(w, r) {...}), even if they are
+// converted first, like requireAuth(http.HandlerFunc(func(w, r) {...})).
+func wrappedHandlers(s *source) (names map[string]bool, lits map[*ast.FuncType]bool) {
+	names, lits = map[string]bool{}, map[*ast.FuncType]bool{}
+	ast.Inspect(s.file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || !hasWord(exprName(call.Fun), authWords) {
+			return true
+		}
+		for _, arg := range call.Args {
+			if name := exprName(arg); name != "" {
+				names[name] = true
+			}
+			// Literals within the literal are not the handler.
+			ast.Inspect(arg, func(n ast.Node) bool {
+				lit, ok := n.(*ast.FuncLit)
+				if ok {
+					lits[lit.Type] = true
+				}
+				return !ok
+			})
+		}
+		return true
+	})
+	return names, lits
+}
+
+// missingAuth reports HTTP handler functions that do not check
+// authentication. Handlers with names that indicate a public endpoint,
+// like health checks or the login page, are not reported, nor are
+// handlers that are wrapped by an authentication middleware.
+func missingAuth(s *source) []Finding {
+	var findings []Finding
+	wrapped, wrappedLits := wrappedHandlers(s)
+	s.funcs(false, func(ft *ast.FuncType, body *ast.BlockStmt, name string) {
+		if !isHandler(ft) || wrapped[name] || wrappedLits[ft] || hasWord(name, publicWords) || checksAuth(body) {
+			return
+		}
+		if name == "" {
+			name = "literal"
+		}
+		findings = append(findings, s.finding(ruleMissingAuth, ft,
+			"HTTP handler %s does not check authentication", name))
+	})
+	return findings
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzers(t *testing.T) {
+	tests := []struct {
+		name     string
+		analyzer func(*source) []Finding
+		src      string
+		want     []int // lines of expected findings
+	}{
+		{"sql concat", sqlInjection, `package p
+func f(db *sql.DB, id string) {
+	db.Query("SELECT * FROM t WHERE id = " + id)
+}`, []int{3}},
+		{"sql sprintf via variable", sqlInjection, `package p
+func f(db *sql.DB, id string) {
+	q := fmt.Sprintf("SELECT * FROM t WHERE id = %s", id)
+	db.QueryRowContext(ctx, q)
+}`, []int{4}},
+		{"sql appended variable", sqlInjection, `package p
+func f(db *sql.DB, id string) {
+	q := "DELETE FROM t"
+	q += " WHERE id = " + id
+	db.Exec(q)
+}`, []int{5}},
+		{"sql in closure", sqlInjection, `package p
+func f(db *sql.DB, id string) {
+	go func() {
+		db.Exec("DELETE FROM t WHERE id = " + id)
+	}()
+}`, []int{4}},
+		{"sql parameters", sqlInjection, `package p
+func f(db *sql.DB, id string) {
+	db.Query("SELECT * FROM t WHERE id = ?", id)
+	db.Exec("SELECT * " + "FROM t")
+	q := "SELECT 1"
+	db.Query(q)
+}`, nil},
+		{"timing equal", timingExploits, `package p
+func f(token, want string) bool {
+	return token == want
+}`, []int{3}},
+		{"timing field and bytes.Equal", timingExploits, `package p
+func f(r req, expectedMAC []byte) bool {
+	if r.Signature != "x" {
+		return false
+	}
+	return bytes.Equal(r.body, expectedMAC)
+}`, []int{3, 6}},
+		{"timing safe", timingExploits, `package p
+func f(token, want string, signal int) bool {
+	if token == "" || signal == 1 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}`, nil},
+		{"auth missing", missingAuth, `package p
+func admin(w http.ResponseWriter, r *http.Request) {}
+func main() {
+	http.HandleFunc("/x", func(w http.ResponseWriter, r *http.Request) {})
+}`, []int{2, 4}},
+		{"auth checked", missingAuth, `package p
+func admin(w http.ResponseWriter, r *http.Request) {
+	if !checkAuth(r) {
+		return
+	}
+}
+func api(w http.ResponseWriter, req *http.Request) {
+	_ = req.Header.Get("Authorization")
+}
+func wrapped(w http.ResponseWriter, r *http.Request) {}
+func healthz(w http.ResponseWriter, r *http.Request) {}
+func notAHandler(w io.Writer, r *http.Request) {}
+func routes() {
+	http.Handle("/w", requireAuth(wrapped))
+}`, nil},
+		{"auth wrapped literal", missingAuth, `package p
+func routes(mux *http.ServeMux) {
+	mux.Handle("/x", requireAuth(func(w http.ResponseWriter, r *http.Request) {}))
+	mux.Handle("/y", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	mux.HandleFunc("/z", func(w http.ResponseWriter, r *http.Request) {})
+}`, []int{5}},
+	}
+	for _, tt := range tests {
+		src, err := parse(goFile{name: "test.go", data: tt.src})
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		var got []int
+		for _, f := range tt.analyzer(src) {
+			got = append(got, f.Pos.Line)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got findings in lines %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := map[string][]string{
+		"token":        {"token"},
+		"apiKey":       {"api", "key"},
+		"expectedHMAC": {"expected", "hmac"},
+		"HMACKey":      {"hmac", "key"},
+		"user_passwd":  {"user", "passwd"},
+	}
+	for in, want := range tests {
+		if got := words(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("words(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
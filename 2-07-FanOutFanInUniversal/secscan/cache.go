@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheMaxAge is how long an entry stays in the cache file
+// without being used.
+const cacheMaxAge = 30 * 24 * time.Hour
+
+// Cache stores the findings of a scanner for the contents of a file.
+// The key consists of the scanner's name and version, and the SHA-256
+// hash of the contents. Hence an unchanged file is not scanned again,
+// even if it was renamed or copied, and a new version of a scanner
+// starts from scratch.
+//
+// A Cache is safe for concurrent use. If two goroutines scan the same
+// contents with the same scanner at the same time, only one of them
+// calls the scanner, and both get the result.
+//
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	group   singleflight.Group
+
+	hits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	// Findings have no file name; the same contents can be in many files.
+	Findings []Finding `json:"findings"`
+	Used     int64     `json:"used"` // Unix time of the last use
+}
+
+// newCache returns an empty cache.
+func newCache() *Cache {
+	return &Cache{entries: map[string]*cacheEntry{}}
+}
+
+// loadCache reads a cache from the file at path.
+// A missing file results in an empty cache.
+func loadCache(path string) (*Cache, error) {
+	c := newCache()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("cache %s: %w", path, err)
+	}
+	// A file with "null" unmarshals into a nil map.
+	if c.entries == nil {
+		c.entries = map[string]*cacheEntry{}
+	}
+	return c, nil
+}
+
+// Save writes the cache to the file at path, leaving out entries that
+// have not been used for cacheMaxAge. It writes to a temporary file
+// first, so that a crash cannot leave a truncated cache behind.
+func (c *Cache) Save(path string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	cutoff := time.Now().Add(-cacheMaxAge).Unix()
+	for k, e := range c.entries {
+		if e.Used < cutoff {
+			delete(c.entries, k)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *Cache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
+
+// scan returns the findings of s for src, from the cache if possible.
+// It reports whether the findings came from the cache. Errors are
+// not cached, so a failing file is scanned again next time.
+func (c *Cache) scan(s Scanner, src *source) ([]Finding, bool, error) {
+	if c == nil {
+		findings, err := scanFile(s, src)
+		return findings, false, err
+	}
+
+	sum := sha256.Sum256([]byte(src.data))
+	key := s.Name() + "@" + s.Version() + ":" + hex.EncodeToString(sum[:])
+	now := time.Now().Unix()
+
+	if e, ok := c.lookup(key, now); ok {
+		c.hits.Add(1)
+		return withFile(e.Findings, src.name), true, nil
+	}
+
+	// Only the caller that runs the function scans the file;
+	// the others wait for its result, which makes them cache hits.
+	computed := false
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		// Another caller's scan may have finished between the
+		// lookup above and Do. Its result is in the cache now.
+		if e, ok := c.lookup(key, now); ok {
+			return e, nil
+		}
+		computed = true
+		findings, err := scanFile(s, src)
+		if err != nil {
+			return nil, err
+		}
+		e := &cacheEntry{Findings: withFile(findings, ""), Used: now}
+		c.mu.Lock()
+		c.entries[key] = e
+		c.mu.Unlock()
+		return e, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if computed {
+		c.misses.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	return withFile(v.(*cacheEntry).Findings, src.name), !computed, nil
+}
+
+// lookup returns the entry for key, and marks it as used at now.
+func (c *Cache) lookup(key string, now int64) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok {
+		e.Used = now
+	}
+	return e, ok
+}
+
+// withFile returns a copy of findings with the file name set to name.
+func withFile(findings []Finding, name string) []Finding {
+	if findings == nil {
+		return nil
+	}
+	res := make([]Finding, len(findings))
+	for i, f := range findings {
+		f.File = name
+		res[i] = f
+	}
+	return res
+}
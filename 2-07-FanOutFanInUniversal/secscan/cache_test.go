@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingScanner counts its calls and takes a while for each.
+type countingScanner struct {
+	version string
+	calls   atomic.Int64
+	err     error
+}
+
+func (s *countingScanner) Name() string    { return "counting" }
+func (s *countingScanner) Version() string { return s.version }
+func (s *countingScanner) Rules() []Rule   { return nil }
+func (s *countingScanner) Scan(src *source) ([]Finding, error) {
+	s.calls.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return []Finding{{Rule: "X001", File: src.name}}, s.err
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	c := newCache()
+	s := &countingScanner{version: "1"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// Different files with the same contents.
+		src := &source{name: string(rune('a' + i)), data: "package p"}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			findings, _, err := c.scan(s, src)
+			if err != nil || len(findings) != 1 || findings[0].File != src.name {
+				t.Errorf("%s: got %v, %v", src.name, findings, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.calls.Load(); n != 1 {
+		t.Errorf("scanner called %d times, want 1", n)
+	}
+	if hits, misses := c.Stats(); hits != 9 || misses != 1 {
+		t.Errorf("got %d hits and %d misses", hits, misses)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	c := newCache()
+	v1 := &countingScanner{version: "1"}
+	v2 := &countingScanner{version: "2"}
+	src := &source{name: "a.go", data: "package p"}
+	changed := &source{name: "a.go", data: "package q"}
+	for _, call := range []struct {
+		s      Scanner
+		src    *source
+		cached bool
+	}{
+		{v1, src, false},
+		{v1, src, true},
+		{v1, changed, false},
+		{v2, src, false},
+	} {
+		if _, cached, _ := c.scan(call.s, call.src); cached != call.cached {
+			t.Errorf("%s@%s on %q: cached = %t, want %t", call.s.Name(), call.s.Version(), call.src.data, cached, call.cached)
+		}
+	}
+
+	// Errors are not cached.
+	failing := &countingScanner{version: "3", err: errors.New("failed")}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.scan(failing, src); err == nil {
+			t.Fatal("no error")
+		}
+	}
+	if n := failing.calls.Load(); n != 2 {
+		t.Errorf("failing scanner called %d times, want 2", n)
+	}
+}
+
+func TestCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "cache.json")
+	scanners := newRegistry().Enabled()
+	run := func() *Result {
+		cache, err := loadCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := testPipeline(4, scanners, cache).scan(context.Background(), &excludes{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	first, second := run(), run()
+	if !reflect.DeepEqual(first.Findings, second.Findings) {
+		t.Errorf("cached findings differ:\n%v\n%v", first.Findings, second.Findings)
+	}
+	for i, st := range second.Scanners {
+		if first.Scanners[i].Cached != 0 || st.Cached != st.Files {
+			t.Errorf("%s: %d of %d files cached in the first run, %d of %d in the second",
+				st.Name, first.Scanners[i].Cached, first.Scanners[i].Files, st.Cached, st.Files)
+		}
+	}
+}
+
+func TestCacheLoadNull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(path, []byte("null"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := parse(goFile{name: "a.go", data: "package a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first store must not panic.
+	if _, _, err := cache.scan(&countingScanner{version: "1"}, src); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy decides what fanOut does with an item when an output channel is full.
+type Policy int
+
+const (
+	// Block waits until the consumer has room for the item.
+	// No item is lost, but the slowest consumer sets the pace for all.
+	Block Policy = iota
+	// DropNewest discards the item that does not fit.
+	DropNewest
+	// DropOldest discards the oldest item in the channel
+	// to make room for the new one.
+	DropOldest
+	// Spill writes items that do not fit to a temporary file,
+	// and feeds them to the consumer later, in order.
+	Spill
+	// Fail stops the fanout with ErrBackpressure.
+	Fail
+)
+
+var policyNames = []string{"block", "drop-newest", "drop-oldest", "spill", "fail"}
+
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// parsePolicy returns the Policy with the given name.
+func parsePolicy(name string) (Policy, error) {
+	for i, n := range policyNames {
+		if n == name {
+			return Policy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown backpressure policy %q", name)
+}
+
+// ErrBackpressure is returned by a fanout with the Fail policy
+// when a consumer cannot keep up.
+var ErrBackpressure = errors.New("consumer cannot keep up")
+
+// FanOutOptions configure a fanout.
+type FanOutOptions[T any] struct {
+	Policy Policy
+	// Wait is how long to wait for room in a full output channel
+	// before the policy applies. It has no effect on Block.
+	Wait time.Duration
+	// SpillDir is the directory for the spill files of the Spill policy.
+	// If empty, the default directory for temporary files is used.
+	SpillDir string
+	// Encode and Decode convert items for the spill file.
+	// If nil, encoding/gob is used, which only handles exported fields.
+	Encode func(T) ([]byte, error)
+	Decode func([]byte) (T, error)
+}
+
+// OutputStats count what happened to the items of a single output.
+type OutputStats struct {
+	Sent    int64 `json:"sent"`    // items in or taken from the output channel
+	Dropped int64 `json:"dropped"` // items lost by DropNewest or DropOldest
+	Spilled int64 `json:"spilled"` // items that took the detour through the spill file
+}
+
+// FanOut copies each item from an input channel to n output channels.
+type FanOut[T any] struct {
+	opts  FanOutOptions[T]
+	chans []chan T
+	stats []outputCounters
+}
+
+type outputCounters struct {
+	sent, dropped, spilled atomic.Int64
+}
+
+// newFanOut creates a fanout with n output channels of size cap.
+// Call Run to start copying. The drop policies need room for at least
+// one item: DropOldest cannot take an item back out of an unbuffered
+// channel, and DropNewest would drop every item that no consumer
+// happens to be waiting for.
+func newFanOut[T any](n, cap int, opts FanOutOptions[T]) (*FanOut[T], error) {
+	if cap < 1 && (opts.Policy == DropNewest || opts.Policy == DropOldest) {
+		return nil, fmt.Errorf("fanout: the %s policy needs a channel size of at least 1, not %d", opts.Policy, cap)
+	}
+	f := &FanOut[T]{
+		opts:  opts,
+		chans: make([]chan T, n),
+		stats: make([]outputCounters, n),
+	}
+	for i := range f.chans {
+		f.chans[i] = make(chan T, cap)
+	}
+	if f.opts.Encode == nil {
+		f.opts.Encode = gobEncode[T]
+	}
+	if f.opts.Decode == nil {
+		f.opts.Decode = gobDecode[T]
+	}
+	return f, nil
+}
+
+// Out returns the i-th output channel.
+func (f *FanOut[T]) Out(i int) <-chan T {
+	return f.chans[i]
+}
+
+// Stats returns the counters of the i-th output. It is safe to call
+// while the fanout is running.
+func (f *FanOut[T]) Stats(i int) OutputStats {
+	c := &f.stats[i]
+	return OutputStats{Sent: c.sent.Load(), Dropped: c.dropped.Load(), Spilled: c.spilled.Load()}
+}
+
+// Run copies each item from in to every output channel, according to
+// the policy, until in is closed or ctx is canceled. It closes the
+// output channels when it returns. Items in spill files are still
+// delivered after in is closed, unless ctx is canceled.
+//
+// Run returns ctx.Err() if ctx is canceled, and an error wrapping
+// ErrBackpressure if the Fail policy applies.
+func (f *FanOut[T]) Run(ctx context.Context, in <-chan T) (err error) {
+	// With the Spill policy, each output gets a queue on disk
+	// and a goroutine that moves items from the queue to the channel.
+	// The goroutines own closing their channels.
+	var spills []*spillQueue[T]
+	var wg sync.WaitGroup
+	if f.opts.Policy == Spill {
+		spills = make([]*spillQueue[T], len(f.chans))
+		for i := range spills {
+			q, qerr := newSpillQueue(f.opts)
+			if qerr != nil {
+				for _, c := range f.chans {
+					close(c)
+				}
+				for _, q := range spills[:i] {
+					q.remove()
+				}
+				return qerr
+			}
+			spills[i] = q
+		}
+		for i, q := range spills {
+			i, q := i, q
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.forward(ctx, f.chans[i], &f.stats[i])
+			}()
+		}
+	}
+	defer func() {
+		if spills == nil {
+			for _, c := range f.chans {
+				close(c)
+			}
+			return
+		}
+		for _, q := range spills {
+			q.finish(err)
+		}
+		wg.Wait()
+		// A forward goroutine that failed to read an item back leaves
+		// the error for the next push. If the input has ended, there
+		// is none, and the error must come out here.
+		for _, q := range spills {
+			q.mu.Lock()
+			qerr := q.err
+			q.mu.Unlock()
+			if err == nil && qerr != nil {
+				err = qerr
+			}
+		}
+		for _, q := range spills {
+			if qerr := q.remove(); err == nil && qerr != nil {
+				err = qerr
+			}
+		}
+	}()
+
+	for {
+		var item T
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case item, ok = <-in:
+		}
+		if !ok {
+			return nil
+		}
+		for i, c := range f.chans {
+			var q *spillQueue[T]
+			if spills != nil {
+				q = spills[i]
+			}
+			if err := f.send(ctx, i, c, q, item); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// send delivers item to the i-th output according to the policy.
+func (f *FanOut[T]) send(ctx context.Context, i int, c chan T, q *spillQueue[T], item T) error {
+	st := &f.stats[i]
+	if f.opts.Policy == Block {
+		select {
+		case c <- item:
+			st.sent.Add(1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Once an item is on disk, all later items must follow it there,
+	// or they would overtake it.
+	if q != nil && q.busy() {
+		return q.push(item, st)
+	}
+
+	// Try to send right away, then wait for room for up to opts.Wait.
+	select {
+	case c <- item:
+		st.sent.Add(1)
+		return nil
+	default:
+	}
+	if f.opts.Wait > 0 {
+		t := time.NewTimer(f.opts.Wait)
+		defer t.Stop()
+		select {
+		case c <- item:
+			st.sent.Add(1)
+			return nil
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch f.opts.Policy {
+	case DropNewest:
+		st.dropped.Add(1)
+	case DropOldest:
+		// The fanout is the only sender, so after taking out
+		// the oldest item, there is room for the new one.
+		// The oldest item was counted as sent when it went in.
+		select {
+		case <-c:
+			st.sent.Add(-1)
+			st.dropped.Add(1)
+		default:
+			// The consumer was quicker.
+		}
+		select {
+		case c <- item:
+			st.sent.Add(1)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case Spill:
+		return q.push(item, st)
+	case Fail:
+		return fmt.Errorf("fanout output %d: %w", i, ErrBackpressure)
+	}
+	return nil
+}
+
+// spillQueue is an unbounded FIFO queue of items in a temporary file.
+// The fanout appends items, and the forward goroutine reads them back.
+type spillQueue[T any] struct {
+	encode func(T) ([]byte, error)
+	decode func([]byte) (T, error)
+	file   *os.File // write handle
+	rfile  *os.File // read handle, at its own offset
+	w      *bufio.Writer
+	r      *bufio.Reader
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  int  // items pushed but not yet delivered
+	unread   int  // items pushed but not yet read back
+	finished bool // no more pushes
+	err      error
+}
+
+func newSpillQueue[T any](opts FanOutOptions[T]) (*spillQueue[T], error) {
+	file, err := os.CreateTemp(opts.SpillDir, "fanout-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	rf, err := os.Open(file.Name())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	q := &spillQueue[T]{
+		encode: opts.Encode,
+		decode: opts.Decode,
+		file:   file,
+		rfile:  rf,
+		w:      bufio.NewWriter(file),
+		r:      bufio.NewReader(rf),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q, nil
+}
+
+// busy reports whether items are waiting in the spill file or
+// are on their way to the consumer.
+func (q *spillQueue[T]) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending > 0
+}
+
+// push appends item to the spill file.
+func (q *spillQueue[T]) push(item T, st *outputCounters) error {
+	b, err := q.encode(item)
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	var n [binary.MaxVarintLen64]byte
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	if _, err := q.w.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))]); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	if _, err := q.w.Write(b); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	// Flush, so that the reader sees the complete record.
+	if err := q.w.Flush(); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	q.pending++
+	q.unread++
+	st.spilled.Add(1)
+	q.cond.Signal()
+	return nil
+}
+
+// finish tells the forward goroutine that no more items follow.
+// If err is not nil, the remaining items are discarded.
+func (q *spillQueue[T]) finish(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finished = true
+	if err != nil {
+		q.err = err
+	}
+	q.cond.Signal()
+}
+
+// forward moves the items from the spill file to c, and closes c
+// when the queue is finished and empty, or ctx is canceled.
+func (q *spillQueue[T]) forward(ctx context.Context, c chan<- T, st *outputCounters) {
+	defer close(c)
+	for {
+		q.mu.Lock()
+		for q.unread == 0 && !q.finished {
+			q.cond.Wait()
+		}
+		if q.err != nil || q.unread == 0 {
+			q.mu.Unlock()
+			return
+		}
+		q.unread--
+		q.mu.Unlock()
+
+		item, err := q.read()
+		if err != nil {
+			// Let the next push fail, rather than losing items silently.
+			q.mu.Lock()
+			q.err = fmt.Errorf("spill: %w", err)
+			q.mu.Unlock()
+			return
+		}
+		select {
+		case c <- item:
+			st.sent.Add(1)
+		case <-ctx.Done():
+			return
+		}
+		q.mu.Lock()
+		q.pending--
+		q.mu.Unlock()
+	}
+}
+
+// read reads the next record. Only forward calls read, and only
+// after push has flushed the record, so no lock is needed.
+func (q *spillQueue[T]) read() (T, error) {
+	var zero T
+	n, err := binary.ReadUvarint(q.r)
+	if err != nil {
+		return zero, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(q.r, b); err != nil {
+		return zero, err
+	}
+	return q.decode(b)
+}
+
+// remove closes and deletes the spill file.
+func (q *spillQueue[T]) remove() error {
+	q.rfile.Close()
+	err := q.file.Close()
+	if rerr := os.Remove(q.file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func gobEncode[T any](item T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(item)
+	return buf.Bytes(), err
+}
+
+func gobDecode[T any](b []byte) (T, error) {
+	var item T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&item)
+	return item, err
+}
+
+// sourceFanOut returns the fanout options for parsed files. A spilled
+// file is stored as its name and contents, and parsed again when it is
+// read back, because a syntax tree cannot be encoded as is.
+func sourceFanOut(policy Policy, wait time.Duration, spillDir string) FanOutOptions[*source] {
+	return FanOutOptions[*source]{
+		Policy:   policy,
+		Wait:     wait,
+		SpillDir: spillDir,
+		Encode: func(s *source) ([]byte, error) {
+			return json.Marshal(spilledFile{s.name, s.data})
+		},
+		Decode: func(b []byte) (*source, error) {
+			var f spilledFile
+			if err := json.Unmarshal(b, &f); err != nil {
+				return nil, err
+			}
+			return parse(goFile{name: f.Name, data: f.Data})
+		},
+	}
+}
+
+type spilledFile struct {
+	Name string
+	Data string
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// numbers returns a closed channel with the numbers 0 to n-1.
+func numbers(n int) chan int {
+	ch := make(chan int, n)
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	return ch
+}
+
+func collect(ch <-chan int) []int {
+	var got []int
+	for i := range ch {
+		got = append(got, i)
+	}
+	return got
+}
+
+func TestFanOutDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy  Policy
+		want    []int
+		dropped int64
+	}{
+		{DropNewest, []int{0, 1}, 8},
+		{DropOldest, []int{8, 9}, 8},
+	}
+	for _, tt := range tests {
+		// Nobody reads until Run returns, so the outputs fill up.
+		f, err := newFanOut(2, 2, FanOutOptions[int]{Policy: tt.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Run(context.Background(), numbers(10)); err != nil {
+			t.Fatalf("%s: %s", tt.policy, err)
+		}
+		for i := 0; i < 2; i++ {
+			if got := collect(f.Out(i)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: output %d got %v, want %v", tt.policy, i, got, tt.want)
+			}
+			if st := f.Stats(i); st.Dropped != tt.dropped || st.Sent != 2 {
+				t.Errorf("%s: output %d stats %+v, want %d dropped", tt.policy, i, st, tt.dropped)
+			}
+		}
+	}
+}
+
+func TestFanOutBlockAndSpillDeliverEverything(t *testing.T) {
+	for _, policy := range []Policy{Block, Spill} {
+		dir := t.TempDir()
+		f, err := newFanOut(2, 1, FanOutOptions[int]{Policy: policy, SpillDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			done <- f.Run(context.Background(), numbers(100))
+		}()
+		// A slow consumer and a fast one.
+		slow := make(chan []int)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			slow <- collect(f.Out(0))
+		}()
+		fast := collect(f.Out(1))
+		if err := <-done; err != nil {
+			t.Fatalf("%s: %s", policy, err)
+		}
+		want := collect(numbers(100))
+		for i, got := range [][]int{<-slow, fast} {
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: output %d got %v", policy, i, got)
+			}
+			if st := f.Stats(i); st.Sent != 100 || st.Dropped != 0 {
+				t.Errorf("%s: output %d stats %+v", policy, i, st)
+			}
+		}
+		if policy == Spill {
+			if st := f.Stats(0); st.Spilled == 0 {
+				t.Errorf("nothing spilled for the slow consumer")
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("spill files left behind: %v", entries)
+			}
+		}
+	}
+}
+
+func TestFanOutSpillReadError(t *testing.T) {
+	// Item 5 cannot be read back, as if the spill file were corrupt.
+	f, err := newFanOut(1, 1, FanOutOptions[int]{
+		Policy:   Spill,
+		SpillDir: t.TempDir(),
+		Encode:   gobEncode[int],
+		Decode: func(b []byte) (int, error) {
+			i, err := gobDecode[int](b)
+			if i == 5 {
+				return 0, errors.New("corrupt record")
+			}
+			return i, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- f.Run(context.Background(), numbers(10))
+	}()
+	// Read only after the input has closed and everything else
+	// went to the spill file, so that no push follows the error.
+	for f.Stats(0).Spilled < 9 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := collect(f.Out(0)); len(got) != 5 {
+		t.Errorf("got %v, want the items before the corrupt one", got)
+	}
+	if err := <-done; err == nil {
+		t.Error("Run succeeded, although spilled items were lost")
+	}
+}
+
+func TestFanOutDropNeedsCapacity(t *testing.T) {
+	for _, policy := range []Policy{DropNewest, DropOldest} {
+		if _, err := newFanOut(1, 0, FanOutOptions[int]{Policy: policy}); err == nil {
+			t.Errorf("%s: no error for unbuffered outputs", policy)
+		}
+	}
+}
+
+func TestFanOutFail(t *testing.T) {
+	f, err := newFanOut(1, 1, FanOutOptions[int]{Policy: Fail, Wait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Run(context.Background(), numbers(3))
+	if !errors.Is(err, ErrBackpressure) {
+		t.Fatalf("got error %v, want ErrBackpressure", err)
+	}
+	if got := collect(f.Out(0)); len(got) != 1 {
+		t.Errorf("got %v, want one item", got)
+	}
+}
+
+func TestFanOutCancel(t *testing.T) {
+	for _, policy := range []Policy{Block, Spill} {
+		ctx, cancel := context.WithCancel(context.Background())
+		in := make(chan int)
+		f, err := newFanOut(1, 0, FanOutOptions[int]{Policy: policy, SpillDir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			done <- f.Run(ctx, in)
+		}()
+		in <- 1
+		cancel()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: got error %v, want context.Canceled", policy, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: Run did not return after cancel", policy)
+		}
+		// The output is closed.
+		for range f.Out(0) {
+		}
+	}
+}
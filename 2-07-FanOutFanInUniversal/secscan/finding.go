@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity is the level of a finding. The values match the
+// result levels of SARIF.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+// Position is a location within a file. Line and column start at 1.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Finding is a potential vulnerability that an analyzer has found.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file"`
+	Pos      Position `json:"position"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s [%s] %s", f.File, f.Pos.Line, f.Pos.Column, f.Rule, f.Severity, f.Message)
+}
+
+// Rule describes a kind of finding. Every scanner reports findings
+// of one or more rules.
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Severity    Severity
+}
+
+// sortFindings sorts findings by file, position, rule, and message.
+// The scanners run concurrently, so findings arrive in random order;
+// sorting them makes every report of the same files identical.
+func sortFindings(fs []Finding) {
+	sort.Slice(fs, func(i, j int) bool {
+		a, b := fs[i], fs[j]
+		switch {
+		case a.File != b.File:
+			return a.File < b.File
+		case a.Pos.Line != b.Pos.Line:
+			return a.Pos.Line < b.Pos.Line
+		case a.Pos.Column != b.Pos.Column:
+			return a.Pos.Column < b.Pos.Column
+		case a.Rule != b.Rule:
+			return a.Rule < b.Rule
+		}
+		return a.Message < b.Message
+	})
+}
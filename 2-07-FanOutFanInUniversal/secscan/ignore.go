@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// ignoreRule is a single pattern of a .gitignore file.
+type ignoreRule struct {
+	base     string // directory of the .gitignore file, relative to the root
+	pattern  []string
+	negate   bool // the pattern starts with "!"
+	dirOnly  bool // the pattern ends with "/"
+	anchored bool // the pattern contains a "/" other than a trailing one
+}
+
+// excludes decides which files and directories the scanner skips.
+// It understands the usual .gitignore syntax: "#" comments, "!" negation,
+// a trailing "/" for directories only, a "/" within the pattern to anchor
+// it to the .gitignore file's directory, and "*", "?", "[...]", and "**"
+// wildcards. As with git, the last matching rule wins.
+type excludes struct {
+	rules []ignoreRule
+}
+
+// add parses a line of a .gitignore file located in directory base.
+// base is relative to the scan root and uses forward slashes.
+func (e *excludes) add(base, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	r.pattern = strings.Split(line, "/")
+	e.rules = append(e.rules, r)
+}
+
+// clone returns a copy of e, so that the rules that a walk loads from
+// .gitignore files do not pile up in e.
+func (e *excludes) clone() *excludes {
+	return &excludes{rules: append([]ignoreRule(nil), e.rules...)}
+}
+
+// load reads the rules of the .gitignore file at file,
+// located in directory base relative to the scan root.
+// A missing file is not an error.
+func (e *excludes) load(base, file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e.add(base, s.Text())
+	}
+	return s.Err()
+}
+
+// match reports whether the file or directory at rel, relative to the
+// scan root and with forward slashes, is excluded.
+func (e *excludes) match(rel string, isDir bool) bool {
+	excluded := false
+	for _, r := range e.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		p := rel
+		if r.base != "" && r.base != "." {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(rel, r.base+"/")
+		}
+		segs := strings.Split(p, "/")
+		if !r.anchored {
+			// A pattern without a slash matches a name at any level.
+			segs = segs[len(segs)-1:]
+		}
+		if matchSegments(r.pattern, segs) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// matchSegments matches path segments against pattern segments.
+// A "**" pattern segment matches zero or more path segments.
+func matchSegments(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segs[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segs[1:])
+}
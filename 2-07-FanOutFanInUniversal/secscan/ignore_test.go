@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestExcludes(t *testing.T) {
+	ex := &excludes{}
+	for _, line := range []string{
+		"# comment",
+		"*_gen.go",
+		"!keep_gen.go",
+		"build/",
+		"/root.go",
+		"docs/**/*.go",
+	} {
+		ex.add("", line)
+	}
+	ex.add("sub", "local.go")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a_gen.go", false, true},
+		{"pkg/b_gen.go", false, true},
+		{"pkg/keep_gen.go", false, false},
+		{"build", true, true},
+		{"pkg/build", true, true},
+		{"build", false, false}, // dir-only pattern
+		{"root.go", false, true},
+		{"pkg/root.go", false, false}, // anchored pattern
+		{"docs/x.go", false, true},
+		{"docs/a/b/x.go", false, true},
+		{"sub/local.go", false, true},
+		{"local.go", false, false}, // rule of sub/.gitignore
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := ex.match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("match(%q, %t) = %t, want %t", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/chans"
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool"
+	"golang.org/x/sync/errgroup"
+)
+
+/*
+The security scanner from 2-06 and 2-07, with real analyzers,
+for scanning real directory trees.
+
+Instead of mockScan, the scanners inspect the syntax tree
+of each file, as produced by go/parser. The built-in scanners are:
+
+- sql, SQL001: SQL queries built by string concatenation or fmt.Sprintf
+  and passed to Query, Exec, and friends
+- timing, TIM001: secrets compared with == or bytes.Equal instead of
+  a constant-time comparison
+- auth, AUTH001: HTTP handlers that do not check authentication
+
+Every scanner implements the Scanner interface and is added to a
+Registry. The registry creates a flag for each scanner, like -auth=false,
+to disable individual scanners, and the pipeline fans out to as many
+scanners as are enabled.
+
+The analyzers are heuristics. They look at names and syntax only,
+without type information, so they can report false positives
+and miss real issues.
+
+The pipeline extends the one from 2-07: the producer walks a directory
+tree, a pool of workers parses each file once, fanOut copies the parsed
+file to each scanner, and chans.Merge collects the findings. The findings are
+sorted before they are reported as text, JSON, or SARIF, so that the
+report does not depend on which scanner finished first.
+
+Try
+
+	go run . testdata/sample
+
+Files and directories listed in .gitignore files are skipped,
+like the generated directory in testdata/sample.
+
+Findings are cached between runs, keyed by the scanner's name and
+version and the hash of the file contents, so a rescan of an unchanged
+tree only parses the files. The cache lives in the user's cache
+directory; -cache="" turns it off.
+
+With -watch, the scanner keeps running. It polls the directory tree
+for changes, waits for a burst of changes to settle, and rescans only
+the changed files. It prints the findings that appeared, with a "+",
+and those that went away, with a "-". If a file changes again while
+it is being rescanned, the rescan is canceled and starts over.
+
+The fanOut of 2-07 waits 90ms for a slow scanner, then drops the file
+for that scanner and prints "Timeout". Here, what happens to a file that
+a scanner cannot take right now is an explicit policy, set with
+-backpressure:
+
+- block: wait until the scanner is ready (the default; nothing is lost)
+- drop-newest: skip the file for this scanner
+- drop-oldest: skip the oldest waiting file instead
+- spill: park the file in a temporary file until the scanner is ready
+- fail: stop the scan with an error
+
+With -wait, the policy applies only after waiting that long.
+The fanout counts dropped and spilled files per scanner, and the
+summary reports the number of files that each scanner has not seen.
+*/
+
+type goFile struct {
+	name string
+	data string
+}
+
+// pipeline holds the configuration of the scanner pipeline.
+type pipeline struct {
+	root     string
+	workers  int // number of parsing workers
+	scanners []Scanner
+	fanout   FanOutOptions[*source]
+	cache    *Cache // nil for no caching
+}
+
+// scan runs the pipeline on the Go files in the directory tree at the
+// root, except those that ex or .gitignore files exclude.
+func (p *pipeline) scan(ctx context.Context, ex *excludes) (*Result, error) {
+	return p.run(ctx, func(ctx context.Context, paths chan<- string) error {
+		return walkFiles(ctx, p.root, ex.clone(), paths)
+	})
+}
+
+// scanFiles runs the pipeline on the given files only.
+func (p *pipeline) scanFiles(ctx context.Context, files []string) (*Result, error) {
+	return p.run(ctx, func(ctx context.Context, paths chan<- string) error {
+		defer close(paths)
+		for _, f := range files {
+			select {
+			case paths <- f:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// run runs the pipeline on the files that produce sends to paths.
+// produce must close paths when done.
+//
+//	produce -> parseFile (pool of workers) -> fanOut -> scanners -> Merge -> collect
+//
+// Canceling ctx stops all stages. The fanin is chans.Merge, which, unlike
+// the fanIn of 2-07, does not leak its goroutines if the collector stops
+// reading.
+func (p *pipeline) run(ctx context.Context, produce func(context.Context, chan<- string) error) (*Result, error) {
+	root, workers, scanners := p.root, p.workers, p.scanners
+	// One fanout channel per scanner, created before any stage starts,
+	// so that a bad configuration leaves nothing running.
+	fo, err := newFanOut(len(scanners), workers, p.fanout)
+	if err != nil {
+		return nil, err
+	}
+	g, ctx := errgroup.WithContext(ctx)
+
+	paths := make(chan string, workers)
+	input := make(chan *source, workers)
+
+	// The producer walks the directory tree, or lists the files to scan.
+	g.Go(func() error {
+		return produce(ctx, paths)
+	})
+
+	// The parsing workers parse each file once. The parsed files go to the
+	// fanout, and all scanners share the same syntax tree. The workers
+	// come from a pool that adds workers, up to the given number, while
+	// files queue up, so that a small scan does not start idle workers.
+	pool := workerpool.New(workerpool.Options{
+		Max:         workers,
+		QueueSize:   workers,
+		IdleTimeout: 100 * time.Millisecond,
+	})
+	g.Go(func() error {
+		defer close(input)
+		defer pool.Close() // runs first: let the queued files finish
+		for path := range paths {
+			path := path
+			if err := pool.Submit(ctx, func() { parseFile(ctx, root, path, input) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Record the files while passing them on to the fanout.
+	var scanned []string
+	counted := make(chan *source)
+	g.Go(func() error {
+		defer close(counted)
+		for src := range input {
+			scanned = append(scanned, src.name)
+			select {
+			case counted <- src:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	// The fanout copies each file to every scanner. The backpressure
+	// policy decides what happens to a file when a scanner falls behind.
+	g.Go(func() error {
+		return fo.Run(ctx, counted)
+	})
+
+	// One result channel and stats entry per scanner.
+	results := make([]<-chan Finding, len(scanners))
+	stats := make([]ScannerStats, len(scanners))
+	for i, s := range scanners {
+		i, s := i, s
+		res := make(chan Finding, workers)
+		results[i] = res
+		g.Go(func() error {
+			return runScanner(ctx, s, p.cache, fo.Out(i), res, &stats[i])
+		})
+	}
+
+	var findings []Finding
+	g.Go(func() error {
+		for f := range chans.Merge(ctx, results...) {
+			findings = append(findings, f)
+		}
+		return nil
+	})
+
+	err = g.Wait()
+	sortFindings(findings)
+	sort.Strings(scanned)
+	for i := range stats {
+		stats[i].Fanout = fo.Stats(i)
+	}
+
+	var rules []Rule
+	for _, s := range scanners {
+		rules = append(rules, s.Rules()...)
+	}
+	return &Result{
+		Files:    len(scanned),
+		Findings: findings,
+		Rules:    rules,
+		Scanners: stats,
+		Scanned:  scanned,
+		Parsers:  pool.Metrics().PeakWorkers,
+	}, err
+}
+
+func main() {
+	workers := flag.Int("workers", runtime.NumCPU(), "maximum number of parsing workers")
+	exclude := flag.String("exclude", "", "comma-separated .gitignore-style patterns to exclude, in addition to .gitignore files")
+	format := flag.String("format", "text", "output format: text, json, or sarif")
+	verbose := flag.Bool("v", false, "print statistics for each scanner")
+	backpressure := flag.String("backpressure", "block", "what to do when a scanner falls behind: block, drop-newest, drop-oldest, spill, or fail")
+	wait := flag.Duration("wait", 0, "how long to wait for a slow scanner before the backpressure policy applies")
+	spillDir := flag.String("spill-dir", "", "directory for spill files (default: the system's temporary directory)")
+	watch := flag.Bool("watch", false, "keep watching the directory, and print findings as they appear (+) and disappear (-)")
+	poll := flag.Duration("poll", 500*time.Millisecond, "how often to check for changes in watch mode")
+	debounce := flag.Duration("debounce", 300*time.Millisecond, "how long to wait for more changes before rescanning in watch mode")
+	cachePath := flag.String("cache", defaultCachePath(), "file to cache findings in between runs; empty to disable the cache")
+	registry := newRegistry()
+	registry.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: secscan [flags] [directory or module path]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "-workers must be at least 1")
+		os.Exit(2)
+	}
+
+	report, ok := reporters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+	policy, err := parsePolicy(*backpressure)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	bp := sourceFanOut(policy, *wait, *spillDir)
+
+	arg := "."
+	if flag.NArg() > 0 {
+		arg = flag.Arg(0)
+	}
+	root, err := resolveRoot(arg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ex := &excludes{}
+	ex.add("", ".git/")
+	for _, p := range strings.Split(*exclude, ",") {
+		ex.add("", p)
+	}
+
+	// Ctrl-C cancels the context and stops the scan.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	scanners := registry.Enabled()
+	if len(scanners) == 0 {
+		fmt.Fprintln(os.Stderr, "all scanners are disabled")
+		os.Exit(2)
+	}
+
+	var cache *Cache
+	if *cachePath != "" {
+		cache, err = loadCache(*cachePath)
+		if err != nil {
+			// A broken cache is not worth failing for; start over.
+			fmt.Fprintf(os.Stderr, "ignoring the cache: %s\n", err)
+			cache = newCache()
+		}
+	}
+
+	p := &pipeline{
+		root:     root,
+		workers:  *workers,
+		scanners: scanners,
+		fanout:   bp,
+		cache:    cache,
+	}
+
+	if *watch {
+		w := &watcher{
+			p:        p,
+			ex:       ex,
+			poll:     *poll,
+			debounce: *debounce,
+			report: func(changes []change) {
+				for _, c := range changes {
+					fmt.Println(c)
+				}
+			},
+			log: os.Stderr,
+		}
+		err := w.run(ctx)
+		if err := cache.Save(*cachePath); err != nil {
+			fmt.Fprintf(os.Stderr, "saving the cache: %s\n", err)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	start := time.Now()
+	res, err := p.scan(ctx, ex)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Even an interrupted scan leaves useful entries.
+	if err := cache.Save(*cachePath); err != nil {
+		fmt.Fprintf(os.Stderr, "saving the cache: %s\n", err)
+	}
+
+	// Write the report to stdout, and everything else to stderr,
+	// so that the report can be piped into other tools.
+	if err := report(os.Stdout, res); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "parsing: %d workers at peak\n", res.Parsers)
+	}
+	for _, st := range res.Scanners {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "scanner %s: %d files (%d cached), %d findings, %d errors, %d spilled, busy for %s\n",
+				st.Name, st.Files, st.Cached, st.Findings, len(st.Errors), st.Fanout.Spilled, st.Busy.Round(time.Microsecond))
+		}
+		if st.Fanout.Dropped > 0 {
+			fmt.Fprintf(os.Stderr, "scanner %s: %d files dropped by the %s policy\n", st.Name, st.Fanout.Dropped, policy)
+		}
+		for _, e := range st.Errors {
+			fmt.Fprintf(os.Stderr, "scanner %s: %s\n", st.Name, e)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "interrupted after scanning %d files in %s\n", res.Files, time.Since(start).Round(time.Millisecond))
+		os.Exit(130)
+	}
+	fmt.Fprintf(os.Stderr, "%d files scanned in %s\n", res.Files, time.Since(start).Round(time.Millisecond))
+}
+
+// defaultCachePath returns the cache file in the user's cache directory,
+// or "" if there is no such directory.
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "secscan", "cache.json")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Result is the outcome of a scan.
+type Result struct {
+	Files    int            // number of files scanned
+	Findings []Finding      // sorted by file and position
+	Rules    []Rule         // rules of all enabled scanners
+	Scanners []ScannerStats // one entry per enabled scanner
+	Scanned  []string       // names of the files scanned, sorted
+	Parsers  int            // peak number of parsing workers
+}
+
+// A reporter writes the result of a scan to w.
+type reporter func(w io.Writer, res *Result) error
+
+var reporters = map[string]reporter{
+	"text":  textReport,
+	"json":  jsonReport,
+	"sarif": sarifReport,
+}
+
+// textReport writes one line per finding, followed by a summary.
+func textReport(w io.Writer, res *Result) error {
+	for _, f := range res.Findings {
+		if _, err := fmt.Fprintln(w, f); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d findings in %d files\n", len(res.Findings), res.Files)
+	return err
+}
+
+// jsonReport writes the findings and the scanner statistics
+// as a single JSON object.
+func jsonReport(w io.Writer, res *Result) error {
+	findings := res.Findings
+	if findings == nil {
+		findings = []Finding{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Files    int            `json:"files"`
+		Findings []Finding      `json:"findings"`
+		Scanners []ScannerStats `json:"scanners"`
+	}{res.Files, findings, res.Scanners})
+}
+
+// The subset of SARIF 2.1.0 that the scanner uses.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID                   string          `json:"id"`
+		Name                 string          `json:"name"`
+		ShortDescription     sarifMessage    `json:"shortDescription"`
+		DefaultConfiguration sarifRuleConfig `json:"defaultConfiguration"`
+	}
+	sarifRuleConfig struct {
+		Level Severity `json:"level"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		RuleIndex int             `json:"ruleIndex"`
+		Level     Severity        `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           sarifRegion           `json:"region"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+	}
+)
+
+// sarifReport writes the findings as a SARIF 2.1.0 log,
+// the format that code scanning tools like GitHub's understand.
+func sarifReport(w io.Writer, res *Result) error {
+	driver := sarifDriver{
+		Name:           "secscan",
+		InformationURI: "https://github.com/AppliedGoCourses/ConcurrencyDeepDive",
+	}
+	index := map[string]int{}
+	for i, r := range res.Rules {
+		index[r.ID] = i
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   r.ID,
+			Name:                 r.Name,
+			ShortDescription:     sarifMessage{r.Description},
+			DefaultConfiguration: sarifRuleConfig{r.Severity},
+		})
+	}
+
+	results := make([]sarifResult, 0, len(res.Findings))
+	for _, f := range res.Findings {
+		results = append(results, sarifResult{
+			RuleID:    f.Rule,
+			RuleIndex: index[f.Rule],
+			Level:     f.Severity,
+			Message:   sarifMessage{f.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: f.File},
+					Region:           sarifRegion{StartLine: f.Pos.Line, StartColumn: f.Pos.Column},
+				},
+			}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
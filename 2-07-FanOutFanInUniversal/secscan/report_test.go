@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestReportsAreDeterministic(t *testing.T) {
+	for name, report := range reporters {
+		var first []byte
+		for i := 0; i < 20; i++ {
+			res, err := testPipeline(4, newRegistry().Enabled(), nil).scan(context.Background(), &excludes{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Timing varies from run to run.
+			for i := range res.Scanners {
+				res.Scanners[i].Busy = 0
+			}
+			var buf bytes.Buffer
+			if err := report(&buf, res); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				first = buf.Bytes()
+				continue
+			}
+			if !bytes.Equal(first, buf.Bytes()) {
+				t.Fatalf("%s: report %d differs from the first one:\n%s\n---\n%s", name, i, first, buf.Bytes())
+			}
+		}
+	}
+}
+
+func TestSARIFReport(t *testing.T) {
+	findings := []Finding{
+		{Rule: ruleTimingEqual.ID, Severity: SeverityWarning, File: "a.go", Pos: Position{3, 9}, Message: "m1"},
+		{Rule: ruleSQLConcat.ID, Severity: SeverityError, File: "b/c.go", Pos: Position{12, 2}, Message: "m2"},
+	}
+	var buf bytes.Buffer
+	rules := []Rule{ruleSQLConcat, ruleTimingEqual}
+	if err := sarifReport(&buf, &Result{Files: 2, Findings: findings, Rules: rules}); err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log: %s", buf.Bytes())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(rules) || len(run.Results) != 2 {
+		t.Fatalf("got %d rules and %d results", len(run.Tool.Driver.Rules), len(run.Results))
+	}
+	for i, res := range run.Results {
+		if r := run.Tool.Driver.Rules[res.RuleIndex]; r.ID != res.RuleID {
+			t.Errorf("result %d: rule index %d points to %s, want %s", i, res.RuleIndex, r.ID, res.RuleID)
+		}
+	}
+	loc := run.Results[1].Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "b/c.go" || loc.Region.StartLine != 12 || loc.Region.StartColumn != 2 {
+		t.Errorf("unexpected location %+v", loc)
+	}
+}
+
+func TestJSONReportWithoutFindings(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jsonReport(&buf, &Result{Files: 3}); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Files    int
+		Findings []Finding
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Files != 3 || got.Findings == nil || len(got.Findings) != 0 {
+		t.Errorf("unexpected report %s", buf.Bytes())
+	}
+}
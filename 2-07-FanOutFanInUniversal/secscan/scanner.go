@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Scanner analyzes a parsed Go file and returns its findings.
+// A scanner is called from a single goroutine, but different scanners
+// run concurrently on the same file, so Scan must not modify src.
+type Scanner interface {
+	// Name is a short, unique name, used for flags and statistics.
+	Name() string
+	// Version changes whenever Scan may return different findings
+	// for the same file, which invalidates cached results.
+	Version() string
+	// Rules are the rules of all findings that Scan can return.
+	Rules() []Rule
+	Scan(src *source) ([]Finding, error)
+}
+
+// analyzer turns an analyzer function with a single rule into a Scanner.
+type analyzer struct {
+	name    string
+	version string
+	rule    Rule
+	fn      func(*source) []Finding
+}
+
+func (a analyzer) Name() string                        { return a.name }
+func (a analyzer) Version() string                     { return a.version }
+func (a analyzer) Rules() []Rule                       { return []Rule{a.rule} }
+func (a analyzer) Scan(src *source) ([]Finding, error) { return a.fn(src), nil }
+
+// Registry holds the available scanners, and whether each one is enabled.
+// All scanners are enabled by default.
+type Registry struct {
+	scanners []Scanner
+	enabled  map[string]*bool
+}
+
+// newRegistry returns a Registry with the built-in scanners.
+func newRegistry() *Registry {
+	r := &Registry{}
+	r.Register(analyzer{"sql", "1", ruleSQLConcat, sqlInjection})
+	r.Register(analyzer{"timing", "1", ruleTimingEqual, timingExploits})
+	r.Register(analyzer{"auth", "1", ruleMissingAuth, missingAuth})
+	return r
+}
+
+// Register adds s to the registry. It panics if a scanner
+// with the same name is already registered.
+func (r *Registry) Register(s Scanner) {
+	if r.enabled == nil {
+		r.enabled = map[string]*bool{}
+	}
+	if _, ok := r.enabled[s.Name()]; ok {
+		panic(fmt.Sprintf("scanner %s registered twice", s.Name()))
+	}
+	enabled := true
+	r.enabled[s.Name()] = &enabled
+	r.scanners = append(r.scanners, s)
+}
+
+// SetEnabled enables or disables the scanner with the given name.
+func (r *Registry) SetEnabled(name string, enabled bool) error {
+	e, ok := r.enabled[name]
+	if !ok {
+		return fmt.Errorf("unknown scanner %s", name)
+	}
+	*e = enabled
+	return nil
+}
+
+// RegisterFlags defines a boolean flag for every registered scanner,
+// so that -auth=false disables the "auth" scanner.
+func (r *Registry) RegisterFlags(fs *flag.FlagSet) {
+	for _, s := range r.scanners {
+		var rules string
+		for i, rule := range s.Rules() {
+			if i > 0 {
+				rules += ", "
+			}
+			rules += rule.ID
+		}
+		fs.BoolVar(r.enabled[s.Name()], s.Name(), true, fmt.Sprintf("enable the %s scanner (%s)", s.Name(), rules))
+	}
+}
+
+// Enabled returns the enabled scanners in the order of registration.
+func (r *Registry) Enabled() []Scanner {
+	var enabled []Scanner
+	for _, s := range r.scanners {
+		if *r.enabled[s.Name()] {
+			enabled = append(enabled, s)
+		}
+	}
+	return enabled
+}
+
+// ScannerStats records the work of a single scanner.
+type ScannerStats struct {
+	Name     string        `json:"name"`
+	Files    int           `json:"files"`
+	Findings int           `json:"findings"`
+	Busy     time.Duration `json:"busyNanos"`
+	Errors   []string      `json:"errors,omitempty"`
+	Cached   int           `json:"cached"` // files whose findings came from the cache
+	Fanout   OutputStats   `json:"fanout"` // what the fanout did with the files for this scanner
+}
+
+// runScanner runs s on every file from data and sends the findings to res.
+// It uses the findings in cache, if any, and adds the new ones.
+// A file that s fails on is recorded in st and does not stop the scanner.
+// runScanner closes res when data is closed and all files are scanned,
+// or when ctx is canceled.
+func runScanner(ctx context.Context, s Scanner, cache *Cache, data <-chan *source, res chan<- Finding, st *ScannerStats) error {
+	defer close(res)
+	st.Name = s.Name()
+	for d := range data {
+		start := time.Now()
+		findings, cached, err := cache.scan(s, d)
+		st.Busy += time.Since(start)
+		st.Files++
+		if err != nil {
+			st.Errors = append(st.Errors, fmt.Sprintf("%s: %s", d.name, err))
+			continue
+		}
+		if cached {
+			st.Cached++
+		}
+		for _, f := range findings {
+			select {
+			case res <- f:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		st.Findings += len(findings)
+	}
+	return nil
+}
+
+// scanFile calls s.Scan and turns a panic into an error,
+// so that a buggy scanner cannot crash the whole pipeline.
+func scanFile(s Scanner, src *source) (findings []Finding, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scanner %s panicked: %v", s.Name(), r)
+		}
+	}()
+	return s.Scan(src)
+}
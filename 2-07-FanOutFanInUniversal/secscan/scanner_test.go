@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// testPipeline returns a pipeline for testdata/sample.
+func testPipeline(workers int, scanners []Scanner, cache *Cache) *pipeline {
+	return &pipeline{
+		root:     "testdata/sample",
+		workers:  workers,
+		scanners: scanners,
+		fanout:   sourceFanOut(Block, 0, ""),
+		cache:    cache,
+	}
+}
+
+// panicScanner fails on every file.
+type panicScanner struct{}
+
+func (panicScanner) Name() string                        { return "panic" }
+func (panicScanner) Version() string                     { return "0" }
+func (panicScanner) Rules() []Rule                       { return nil }
+func (panicScanner) Scan(src *source) ([]Finding, error) { panic("boom") }
+
+func TestDisabledScannersDoNotRun(t *testing.T) {
+	r := newRegistry()
+	if err := r.SetEnabled("sql", false); err != nil {
+		t.Fatal(err)
+	}
+	res, err := testPipeline(2, r.Enabled(), nil).scan(context.Background(), &excludes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Scanners) != 2 {
+		t.Fatalf("got stats for %d scanners, want 2", len(res.Scanners))
+	}
+	for _, f := range res.Findings {
+		if f.Rule == ruleSQLConcat.ID {
+			t.Errorf("disabled scanner reported %s", f)
+		}
+	}
+	for _, r := range res.Rules {
+		if r.ID == ruleSQLConcat.ID {
+			t.Errorf("rules of the disabled scanner are in the result")
+		}
+	}
+}
+
+func TestFailingScannerIsReported(t *testing.T) {
+	r := newRegistry()
+	r.Register(panicScanner{})
+	res, err := testPipeline(2, r.Enabled(), nil).scan(context.Background(), &excludes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 6 {
+		t.Errorf("got %d findings, want 6", len(res.Findings))
+	}
+	st := res.Scanners[len(res.Scanners)-1]
+	if st.Name != "panic" || len(st.Errors) != res.Files {
+		t.Fatalf("got stats %+v, want one error per file", st)
+	}
+	if !strings.Contains(st.Errors[0], "boom") {
+		t.Errorf("unexpected error %q", st.Errors[0])
+	}
+}
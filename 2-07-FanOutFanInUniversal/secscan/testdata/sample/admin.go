@@ -0,0 +1,26 @@
+package admin
+
+import "net/http"
+
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("all the secrets"))
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Write([]byte("all the users"))
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func routes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin", adminHandler)
+	mux.HandleFunc("/users", usersHandler)
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {})
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+func FindUser(db *sql.DB, name string) (*sql.Rows, error) {
+	return db.Query("SELECT * FROM users WHERE name = '" + name + "'")
+}
+
+func DeleteUser(db *sql.DB, id string) error {
+	q := fmt.Sprintf("DELETE FROM users WHERE id = %s", id)
+	_, err := db.Exec(q)
+	return err
+}
+
+func CountUsers(db *sql.DB, role string) *sql.Row {
+	return db.QueryRow("SELECT count(*) FROM users WHERE role = ?", role)
+}
@@ -0,0 +1,5 @@
+package various
+
+func Various() {
+	println("Hello, world!")
+}
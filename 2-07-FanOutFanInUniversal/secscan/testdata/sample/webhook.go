@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+)
+
+func valid(body, signature, key []byte) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return bytes.Equal(mac.Sum(nil), signature)
+}
+
+func checkAPIKey(r *http.Request, apiKey string) bool {
+	return r.Header.Get("X-Api-Key") == apiKey
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// resolveRoot returns the directory to scan. arg is either a directory,
+// or the path of a module that the go command can locate, such as the
+// current module or one of its dependencies.
+func resolveRoot(arg string) (string, error) {
+	if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+		return arg, nil
+	}
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", arg).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is neither a directory nor a module known to the go command", arg)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("module %s is not downloaded, run 'go mod download %s'", arg, arg)
+	}
+	return dir, nil
+}
+
+// walkFiles walks the directory tree at root and sends the path of every
+// Go file that is not excluded to paths. While descending, it adds the
+// rules of every .gitignore file it finds to ex. walkFiles closes paths
+// when done, and stops early if ctx is canceled.
+func walkFiles(ctx context.Context, root string, ex *excludes, paths chan<- string) error {
+	defer close(paths)
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// An unreadable file or directory does not stop the scan.
+			fmt.Fprintf(os.Stderr, "skipping %s: %s\n", p, err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && ex.match(rel, true) {
+				return filepath.SkipDir
+			}
+			return ex.load(rel, filepath.Join(p, ".gitignore"))
+		}
+		if !strings.HasSuffix(p, ".go") || ex.match(rel, false) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case paths <- p:
+		}
+		return nil
+	})
+}
+
+// parseFile reads and parses the file at path, and sends the parsed file
+// to out. A file that cannot be read or parsed is reported and skipped.
+func parseFile(ctx context.Context, root, path string, out chan<- *source) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipping %s: %s\n", path, err)
+		return
+	}
+	name, err := filepath.Rel(root, path)
+	if err != nil {
+		name = path
+	}
+	src, err := parse(goFile{name: filepath.ToSlash(name), data: string(data)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipping %s\n", err)
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case out <- src:
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileState tells versions of a file apart, well enough for polling.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// A change is a finding that appeared or disappeared.
+type change struct {
+	added   bool
+	finding Finding
+}
+
+func (c change) String() string {
+	if c.added {
+		return "+ " + c.finding.String()
+	}
+	return "- " + c.finding.String()
+}
+
+// watcher scans a directory tree, then polls it for changes and rescans
+// the changed files.
+//
+// A burst of changes, like a "save all" in an editor or a git checkout,
+// is debounced: the watcher waits until no more changes come in for the
+// debounce period, then rescans all changed files at once, through the
+// same pipeline as a full scan. If a file changes again while it is being
+// rescanned, the rescan is canceled and starts over with the new changes.
+type watcher struct {
+	p        *pipeline
+	ex       *excludes
+	poll     time.Duration
+	debounce time.Duration
+	// report receives the changes of each scan, sorted by file
+	// and position. It is not called for scans without changes.
+	report func([]change)
+	log    io.Writer // for errors and progress
+
+	findings map[string][]Finding // current findings by file name
+}
+
+// scanDone is the outcome of a scan that ran in the background.
+type scanDone struct {
+	res *Result
+	err error
+}
+
+// run scans the tree and reports all findings as additions, then
+// watches for changes until ctx is canceled.
+func (w *watcher) run(ctx context.Context) error {
+	known, err := w.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := w.p.scan(ctx, w.ex)
+	if err != nil {
+		return err
+	}
+	w.findings = map[string][]Finding{}
+	w.apply(res, res.Scanned)
+	fmt.Fprintf(w.log, "%d files scanned, watching for changes\n", res.Files)
+
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+
+	pending := map[string]bool{} // changed files, not yet scanned
+	var debounce *time.Timer
+	var debounced <-chan time.Time // nil while no debounce is running
+
+	// The current rescan, if any.
+	var running map[string]bool
+	var cancelRun context.CancelFunc
+	restart := false // the running scan was canceled to start over
+	done := make(chan scanDone, 1)
+
+	start := func() {
+		files := make([]string, 0, len(pending))
+		for f := range pending {
+			files = append(files, f)
+		}
+		sort.Strings(files)
+		running, pending = pending, map[string]bool{}
+
+		// Removed files have no findings anymore; apply
+		// takes care of them.
+		var exist []string
+		for _, f := range files {
+			if _, err := os.Stat(f); err == nil {
+				exist = append(exist, f)
+			}
+		}
+		var runCtx context.Context
+		runCtx, cancelRun = context.WithCancel(ctx)
+		go func() {
+			res, err := w.p.scanFiles(runCtx, exist)
+			done <- scanDone{res, err}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running != nil {
+				cancelRun()
+				<-done
+			}
+			return nil
+
+		case <-ticker.C:
+			cur, err := w.snapshot(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintln(w.log, err)
+				}
+				continue
+			}
+			changed := changedFiles(known, cur)
+			known = cur
+			if len(changed) == 0 {
+				continue
+			}
+			for _, f := range changed {
+				pending[f] = true
+			}
+			// Wait until the burst of changes is over.
+			if debounce == nil {
+				debounce = time.NewTimer(w.debounce)
+			} else {
+				if !debounce.Stop() && debounced != nil {
+					<-debounce.C
+				}
+				debounce.Reset(w.debounce)
+			}
+			debounced = debounce.C
+
+		case <-debounced:
+			debounced = nil
+			if running == nil {
+				start()
+				continue
+			}
+			// Files that changed again are out of date in the running
+			// scan: cancel it and scan everything again. Otherwise,
+			// the pending files wait for the running scan to finish.
+			for f := range pending {
+				if running[f] {
+					restart = true
+					cancelRun()
+					break
+				}
+			}
+
+		case d := <-done:
+			cancelRun()
+			files := running
+			running = nil
+			switch {
+			case restart:
+				restart = false
+				for f := range files {
+					pending[f] = true
+				}
+			case errors.Is(d.err, context.Canceled):
+				// ctx is canceled; the next iteration returns.
+			case d.err != nil:
+				fmt.Fprintln(w.log, d.err)
+			default:
+				names := make([]string, 0, len(files))
+				for f := range files {
+					names = append(names, w.name(f))
+				}
+				w.apply(d.res, names)
+				for _, st := range d.res.Scanners {
+					for _, e := range st.Errors {
+						fmt.Fprintf(w.log, "scanner %s: %s\n", st.Name, e)
+					}
+				}
+			}
+			if len(pending) > 0 && debounced == nil {
+				start()
+			}
+		}
+	}
+}
+
+// apply updates the findings of the given files from the result of
+// a scan, and reports the changes. A file that the scan skipped because
+// it could not be parsed, like a file in the middle of an edit, keeps
+// its findings. A file that no longer exists loses them.
+func (w *watcher) apply(res *Result, names []string) {
+	byFile := map[string][]Finding{}
+	for _, f := range res.Findings {
+		byFile[f.File] = append(byFile[f.File], f)
+	}
+	scanned := map[string]bool{}
+	for _, n := range res.Scanned {
+		scanned[n] = true
+	}
+
+	var changes []change
+	for _, n := range names {
+		if !scanned[n] {
+			if _, err := os.Stat(filepath.Join(w.p.root, filepath.FromSlash(n))); err == nil {
+				continue
+			}
+		}
+		changes = append(changes, diffFindings(w.findings[n], byFile[n])...)
+		if len(byFile[n]) == 0 {
+			delete(w.findings, n)
+		} else {
+			w.findings[n] = byFile[n]
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i].finding, changes[j].finding
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		// Within a line, removals come first.
+		return !changes[i].added && changes[j].added
+	})
+	w.report(changes)
+}
+
+// diffFindings returns the findings that are in old but not in new as
+// removals, followed by those that are in new but not in old as additions.
+func diffFindings(old, new []Finding) []change {
+	count := map[Finding]int{}
+	for _, f := range old {
+		count[f]++
+	}
+	var added []change
+	for _, f := range new {
+		if count[f] > 0 {
+			count[f]--
+			continue
+		}
+		added = append(added, change{added: true, finding: f})
+	}
+	var changes []change
+	for _, f := range old {
+		if count[f] > 0 {
+			count[f]--
+			changes = append(changes, change{added: false, finding: f})
+		}
+	}
+	return append(changes, added...)
+}
+
+// snapshot returns the state of all files that a full scan would scan,
+// by path.
+func (w *watcher) snapshot(ctx context.Context) (map[string]fileState, error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- walkFiles(ctx, w.p.root, w.ex.clone(), paths)
+	}()
+	files := map[string]fileState{}
+	for p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue // removed since the walk saw it
+		}
+		files[p] = fileState{fi.ModTime(), fi.Size()}
+	}
+	return files, <-errc
+}
+
+// changedFiles returns the paths of files that are new, modified,
+// or removed in cur compared to old.
+func changedFiles(old, cur map[string]fileState) []string {
+	var changed []string
+	for p, s := range cur {
+		if o, ok := old[p]; !ok || o != s {
+			changed = append(changed, p)
+		}
+	}
+	for p := range old {
+		if _, ok := cur[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+// name returns the file name of path in findings.
+func (w *watcher) name(path string) string {
+	rel, err := filepath.Rel(w.p.root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startWatcher runs a watcher on dir and returns a channel with the
+// reported changes, one string per scan.
+func startWatcher(t *testing.T, dir string, scanners []Scanner) <-chan string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := make(chan string, 10)
+	w := &watcher{
+		p: &pipeline{
+			root:     dir,
+			workers:  2,
+			scanners: scanners,
+			fanout:   sourceFanOut(Block, 0, ""),
+		},
+		ex:       &excludes{},
+		poll:     5 * time.Millisecond,
+		debounce: 20 * time.Millisecond,
+		report: func(changes []change) {
+			var lines []string
+			for _, c := range changes {
+				lines = append(lines, c.String())
+			}
+			reports <- strings.Join(lines, "\n")
+		},
+		log: io.Discard,
+	}
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return reports
+}
+
+func nextReport(t *testing.T, reports <-chan string) string {
+	t.Helper()
+	select {
+	case r := <-reports:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report")
+		return ""
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.go"), "package p\n\nfunc f(token, want string) bool { return token == want }\n")
+	reports := startWatcher(t, dir, newRegistry().Enabled())
+
+	if r := nextReport(t, reports); !strings.HasPrefix(r, "+ a.go:3:") {
+		t.Fatalf("initial scan: got %q", r)
+	}
+
+	// Fix the finding, and add a new file with a finding.
+	writeFile(t, filepath.Join(dir, "a.go"), "package p\n\nfunc f(token, want string) bool { return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 }\n")
+	writeFile(t, filepath.Join(dir, "b.go"), "package p\n\nfunc g(db *sql.DB, id string) { db.Exec(\"DELETE FROM t WHERE id = \" + id) }\n")
+	r := nextReport(t, reports)
+	lines := strings.Split(r, "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "- a.go:3:") || !strings.HasPrefix(lines[1], "+ b.go:3:") {
+		t.Fatalf("after the edits: got\n%s", r)
+	}
+
+	// A syntax error keeps the findings, removing the file drops them.
+	writeFile(t, filepath.Join(dir, "b.go"), "package p\n\nfunc g(")
+	select {
+	case r := <-reports:
+		t.Fatalf("after breaking b.go: got\n%s", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+	os.Remove(filepath.Join(dir, "b.go"))
+	if r := nextReport(t, reports); !strings.HasPrefix(r, "- b.go:3:") || strings.Contains(r, "\n") {
+		t.Fatalf("after removing b.go: got\n%s", r)
+	}
+}
+
+// contentScanner reports a finding with the contents of each file,
+// and takes a while for files that contain "slow".
+type contentScanner struct {
+	scans atomic.Int64
+}
+
+func (s *contentScanner) Name() string    { return "content" }
+func (s *contentScanner) Version() string { return "1" }
+func (s *contentScanner) Rules() []Rule   { return nil }
+func (s *contentScanner) Scan(src *source) ([]Finding, error) {
+	s.scans.Add(1)
+	if strings.Contains(src.data, "slow") {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return []Finding{{Rule: "C001", File: src.name, Pos: Position{1, 1}, Message: src.data}}, nil
+}
+
+func TestWatchRestartsScanOfChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.go")
+	writeFile(t, path, "package v0")
+	s := &contentScanner{}
+	reports := startWatcher(t, dir, []Scanner{s})
+	if r := nextReport(t, reports); !strings.HasSuffix(r, "package v0") {
+		t.Fatalf("initial scan: got %q", r)
+	}
+
+	// Change the file while its rescan is in flight.
+	writeFile(t, path, "package v1 // slow")
+	deadline := time.Now().Add(5 * time.Second)
+	for s.scans.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no rescan")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	writeFile(t, path, "package v2 // a bit longer")
+
+	r := nextReport(t, reports)
+	if strings.Contains(r, "v1") || !strings.Contains(r, "- a.go:1:1: C001 [] package v0") || !strings.Contains(r, "+ a.go:1:1: C001 [] package v2") {
+		t.Fatalf("got\n%s", r)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !race
+
+// BadGetFile has a data race by design,
+// so this test would fail with -race.
+
+package main
+
+import "testing"
+
+func TestBadGetFile(t *testing.T) {
+	b := newFixtureBuffer(t)
+	if failed := getConcurrently(b, false, 1000); failed > 0 {
+		t.Errorf("%d calls got no data", failed)
+	}
+	// BadGetFile loads the file once or more; how often depends
+	// on the scheduler.
+	if n := b.Loads(); n < 1 {
+		t.Errorf("file loaded %d times, want at least 1", n)
+	}
+	t.Logf("BadGetFile loaded the file %d times", b.Loads())
+}
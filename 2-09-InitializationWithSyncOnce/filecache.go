@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheOptions configure a FileCache.
+type CacheOptions struct {
+	// TTL is how long a loaded file stays in the cache.
+	// Zero means until it is evicted.
+	TTL time.Duration
+	// MaxEntries is the number of files the cache holds. If a load
+	// exceeds it, the least recently used file is evicted.
+	// Zero means no limit.
+	MaxEntries int
+	// ErrorTTL is how long a failed load is cached. Callers within
+	// that period get the error without another load. Zero means
+	// errors are not cached, and the next caller loads again.
+	ErrorTTL time.Duration
+}
+
+// CacheStats count the requests to a FileCache.
+type CacheStats struct {
+	Hits         int64 // requests served from the cache
+	Misses       int64 // requests that needed a load
+	Loads        int64 // loads that ran; at most Misses
+	Deduplicated int64 // misses that got the result of another caller's load
+	Evictions    int64 // entries evicted to stay within MaxEntries
+}
+
+// FileCache keeps the contents of many files in memory, loading each
+// file the first time it is requested. It is the keyed counterpart of
+// FileBuffer.
+//
+// Concurrent requests for the same file share one load, and requests
+// for different files load in parallel. The load does not run in the
+// context of any particular caller: if the caller that started it gives
+// up, the others still get the result, and so does the cache.
+type FileCache struct {
+	opts CacheOptions
+	load func(ctx context.Context, path string) ([]byte, error)
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry
+	lru     *list.List               // most recently used first
+	group   singleflight.Group
+
+	hits, misses, loads, deduplicated, evictions int64 // atomic
+}
+
+type cacheEntry struct {
+	path    string
+	data    []byte
+	err     error
+	expires time.Time // zero means never
+}
+
+// NewFileCache returns an empty cache with the given options.
+func NewFileCache(opts CacheOptions) *FileCache {
+	return &FileCache{
+		opts: opts,
+		load: func(_ context.Context, path string) ([]byte, error) {
+			return os.ReadFile(path)
+		},
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Get returns the contents of the file at path, loading it if it is not
+// in the cache. If the file is being loaded for another caller, Get
+// waits for that load, or until ctx ends. The caller must not modify
+// the returned slice.
+func (c *FileCache) Get(ctx context.Context, path string) ([]byte, error) {
+	if e, ok := c.lookup(path); ok {
+		atomic.AddInt64(&c.hits, 1)
+		return e.data, e.err
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	// Only the caller whose function runs loads the file.
+	// The variable is read after the result arrives, which is
+	// after the function returned.
+	loaded := false
+	ch := c.group.DoChan(path, func() (interface{}, error) {
+		// Another caller's load may have finished between the lookup
+		// above and DoChan. Its result is in the cache now.
+		if e, ok := c.lookup(path); ok {
+			return e.data, e.err
+		}
+		loaded = true
+		atomic.AddInt64(&c.loads, 1)
+		data, err := c.load(context.Background(), path)
+		c.store(path, data, err)
+		return data, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if !loaded {
+			atomic.AddInt64(&c.deduplicated, 1)
+		}
+		data, _ := r.Val.([]byte)
+		return data, r.Err
+	}
+}
+
+// Len returns the number of entries in the cache,
+// including expired ones that have not been removed yet.
+func (c *FileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the counts of the cache's requests so far.
+func (c *FileCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:         atomic.LoadInt64(&c.hits),
+		Misses:       atomic.LoadInt64(&c.misses),
+		Loads:        atomic.LoadInt64(&c.loads),
+		Deduplicated: atomic.LoadInt64(&c.deduplicated),
+		Evictions:    atomic.LoadInt64(&c.evictions),
+	}
+}
+
+// lookup returns the entry for path, unless there is none or it expired.
+func (c *FileCache) lookup(path string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, path)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// store adds the result of a load to the cache,
+// and evicts the least recently used entries if there are too many.
+func (c *FileCache) store(path string, data []byte, err error) {
+	ttl := c.opts.TTL
+	if err != nil {
+		if c.opts.ErrorTTL <= 0 {
+			return
+		}
+		ttl = c.opts.ErrorTTL
+	}
+	e := &cacheEntry{path: path, data: data, err: err}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		c.lru.Remove(el)
+	}
+	c.entries[path] = c.lru.PushFront(e)
+	for c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).path)
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
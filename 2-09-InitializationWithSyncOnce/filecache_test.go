@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestCache returns a cache whose loads return the path as contents,
+// after the given delay, and count the loads per path.
+func newTestCache(opts CacheOptions, delay time.Duration) (*FileCache, map[string]*int64) {
+	c := NewFileCache(opts)
+	loads := map[string]*int64{}
+	for _, p := range []string{"a", "b", "c", "missing"} {
+		loads[p] = new(int64)
+	}
+	c.load = func(_ context.Context, path string) ([]byte, error) {
+		atomic.AddInt64(loads[path], 1)
+		time.Sleep(delay)
+		if path == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte(path), nil
+	}
+	return c, loads
+}
+
+func TestFileCacheSingleflight(t *testing.T) {
+	c, loads := newTestCache(CacheOptions{}, 20*time.Millisecond)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 90; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			data, err := c.Get(context.Background(), path)
+			if err != nil || string(data) != path {
+				t.Errorf("Get(%q) = %q, %v", path, data, err)
+			}
+		}([]string{"a", "b", "c"}[i%3])
+	}
+	wg.Wait()
+
+	for _, p := range []string{"a", "b", "c"} {
+		if n := atomic.LoadInt64(loads[p]); n != 1 {
+			t.Errorf("%s loaded %d times, want 1", p, n)
+		}
+	}
+	// Different keys load in parallel.
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("loading 3 files took %v, want about 20ms", d)
+	}
+	st := c.Stats()
+	if st.Loads != 3 || st.Hits+st.Misses != 90 || st.Misses-st.Deduplicated != 3 {
+		t.Errorf("stats = %+v, want 3 loads, 90 requests, 3 undeduplicated misses", st)
+	}
+}
+
+func TestFileCacheErrors(t *testing.T) {
+	c, loads := newTestCache(CacheOptions{}, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background(), "missing"); err == nil {
+			t.Fatal("no error")
+		}
+	}
+	if n := *loads["missing"]; n != 3 {
+		t.Errorf("without ErrorTTL: loaded %d times, want 3", n)
+	}
+
+	c, loads = newTestCache(CacheOptions{ErrorTTL: 30 * time.Millisecond}, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background(), "missing"); err == nil {
+			t.Fatal("no error")
+		}
+	}
+	if n := *loads["missing"]; n != 1 {
+		t.Errorf("with ErrorTTL: loaded %d times, want 1", n)
+	}
+	time.Sleep(40 * time.Millisecond)
+	c.Get(context.Background(), "missing")
+	if n := *loads["missing"]; n != 2 {
+		t.Errorf("after ErrorTTL: loaded %d times, want 2", n)
+	}
+}
+
+func TestFileCacheTTL(t *testing.T) {
+	c, loads := newTestCache(CacheOptions{TTL: 30 * time.Millisecond}, 0)
+	c.Get(context.Background(), "a")
+	c.Get(context.Background(), "a")
+	if n := *loads["a"]; n != 1 {
+		t.Errorf("loaded %d times within TTL, want 1", n)
+	}
+	time.Sleep(40 * time.Millisecond)
+	c.Get(context.Background(), "a")
+	if n := *loads["a"]; n != 2 {
+		t.Errorf("loaded %d times after TTL, want 2", n)
+	}
+}
+
+func TestFileCacheLRU(t *testing.T) {
+	c, loads := newTestCache(CacheOptions{MaxEntries: 2}, 0)
+	ctx := context.Background()
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	c.Get(ctx, "a") // b is now the least recently used
+	c.Get(ctx, "c") // evicts b
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	if *loads["a"] != 1 || *loads["b"] != 2 {
+		t.Errorf("loads: a %d, b %d, want a 1, b 2", *loads["a"], *loads["b"])
+	}
+	if st := c.Stats(); st.Evictions != 2 {
+		t.Errorf("%d evictions, want 2", st.Evictions)
+	}
+}
+
+func TestFileCacheContext(t *testing.T) {
+	c, loads := newTestCache(CacheOptions{}, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The load went on without the caller, and filled the cache.
+	time.Sleep(60 * time.Millisecond)
+	if data, err := c.Get(context.Background(), "a"); err != nil || string(data) != "a" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if n := atomic.LoadInt64(loads["a"]); n != 1 {
+		t.Errorf("loaded %d times, want 1", n)
+	}
+}
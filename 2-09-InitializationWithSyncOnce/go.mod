@@ -1,3 +1,5 @@
 module github.com/AppliedGoCourses/ConcurrencyDeepDive/2-08-InitializationWithSyncOnce/synconce
 
 go 1.18
+
+require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
@@ -1,17 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Used for muting output during tests.
+var stdout io.Writer = os.Stdout
+
 // FileBuffer keeps a file's contents in memory.
 // The file is loaded only once.
 type FileBuffer struct {
 	data []byte
 	once sync.Once
+
+	path  string
+	file  *OnceValue[[]byte]
+	loads int64 // number of times the file was read; atomic
+}
+
+// NewFileBuffer returns a FileBuffer for the file at path.
+// GetFile retries a failed load after a backoff period that
+// starts at 100 milliseconds and grows up to 5 seconds.
+func NewFileBuffer(path string) *FileBuffer {
+	f := &FileBuffer{path: path}
+	f.file = NewOnceValue(func(ctx context.Context) ([]byte, error) {
+		return f.read("GetFile")
+	}, ExponentialBackoff(100*time.Millisecond, 5*time.Second))
+	return f
 }
 
 // BadGetFile loads a file if not already cached,
@@ -24,12 +50,10 @@ func (f *FileBuffer) BadGetFile() []byte {
 	if f.data != nil {
 		return f.data
 	}
-	// A very simple way to demonstrate that BadGetFile()
-	// is not safe for concurrent use.
-	fmt.Println("BadGetFile: loading 'data'")
-
+	// f.read prints a message for every load: a very simple way
+	// to demonstrate that BadGetFile() is not safe for concurrent use.
 	var err error
-	f.data, err = os.ReadFile("data")
+	f.data, err = f.read("BadGetFile")
 	// quick  & dirty error handling for brevity
 	if err != nil {
 		log.Fatalln(err)
@@ -45,10 +69,8 @@ func (f *FileBuffer) GoodGetFile() []byte {
 		log.Fatalln("receiver must not be nil")
 	}
 	f.once.Do(func() {
-		fmt.Println("GoodGetFile: loading 'data'")
-
 		var err error
-		f.data, err = os.ReadFile("data")
+		f.data, err = f.read("GoodGetFile")
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -56,20 +78,255 @@ func (f *FileBuffer) GoodGetFile() []byte {
 	return f.data
 }
 
-func main() {
-	b := FileBuffer{}
+// read reads the file, and counts and reports the load.
+// A FileBuffer that was not created by NewFileBuffer reads "data".
+func (f *FileBuffer) read(caller string) ([]byte, error) {
+	path := f.path
+	if path == "" {
+		path = "data"
+	}
+	atomic.AddInt64(&f.loads, 1)
+	fmt.Fprintf(stdout, "%s: loading '%s'\n", caller, path)
+	return os.ReadFile(path)
+}
+
+// Loads returns the number of times the file was read.
+func (f *FileBuffer) Loads() int64 {
+	return atomic.LoadInt64(&f.loads)
+}
 
+// GetFile loads the file once, like GoodGetFile, but returns an error
+// instead of ending the program if the file cannot be read. A later
+// call tries again. If the file is being loaded by another goroutine,
+// GetFile waits until ctx ends.
+func (f *FileBuffer) GetFile(ctx context.Context) ([]byte, error) {
+	if f == nil || f.file == nil {
+		return nil, errors.New("FileBuffer must be created with NewFileBuffer")
+	}
+	return f.file.GetContext(ctx)
+}
+
+// getConcurrently calls BadGetFile, or GoodGetFile if good is set, from n
+// goroutines at once, and returns the number of calls that got no data.
+func getConcurrently(b *FileBuffer, good bool, n int) int {
+	var failed int64
 	var wg sync.WaitGroup
-	for i := 1; i < 1000; i++ {
+	for i := 0; i < n; i++ {
 		wg.Add(1)
 		go func() {
-			// Test this call with both BadGetFile() and GoodGetFile().
-			data := b.BadGetFile()
+			// Done must be called by the goroutine, not by the loop
+			// that starts it. Otherwise, wg.Wait returns before
+			// the goroutines have done their work.
+			defer wg.Done()
+			var data []byte
+			if good {
+				data = b.GoodGetFile()
+			} else {
+				data = b.BadGetFile()
+			}
 			if data == nil {
-				log.Println("error: file not loaded")
+				atomic.AddInt64(&failed, 1)
 			}
 		}()
-		wg.Done()
 	}
 	wg.Wait()
+	return int(failed)
+}
+
+// writeFixture creates a file of the given size to load,
+// unless it exists already.
+func writeFixture(path string, size int) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	line := "This is a very large data file.\n"
+	data := bytes.Repeat([]byte(line), size/len(line)+1)[:size]
+	return os.WriteFile(path, data, 0o644)
+}
+
+// retryDemo reads a file that does not exist yet. The first call fails,
+// and the calls during the backoff period fail without touching the file
+// system. After the file appears, the next attempt succeeds, and all
+// later calls get the cached contents.
+func retryDemo() {
+	dir, err := os.MkdirTemp("", "synconce")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	b := NewFileBuffer(path)
+
+	for i := 0; i < 20; i++ {
+		if i == 10 {
+			fmt.Println("creating", path)
+			if err := os.WriteFile(path, []byte("Hello, sync.Once"), 0o644); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		data, err := b.GetFile(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("call %2d: %s\n", i, err)
+		} else {
+			fmt.Printf("call %2d: %q\n", i, data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// reloadDemo edits a config file while readers keep reading it. The
+// readers never wait for a reload; a subscriber prints each reload.
+func reloadDemo() {
+	dir, err := os.MkdirTemp("", "synconce")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("level=info"), 0o644); err != nil {
+		log.Fatalln(err)
+	}
+	b, err := NewReloadingBuffer(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.Watch(ctx, 20*time.Millisecond)
+	}()
+
+	events, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-events:
+				fmt.Printf("%s: %q\n", e, b.Data())
+			}
+		}
+	}()
+
+	var reads int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_ = b.Data()
+				atomic.AddInt64(&reads, 1)
+			}
+		}()
+	}
+
+	for _, config := range []string{"level=debug", "level=debug", "level=warn"} {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Printf("writing %q\n", config)
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	fmt.Println("removing the file")
+	os.Remove(path)
+	time.Sleep(200 * time.Millisecond)
+
+	cancel()
+	wg.Wait()
+	fmt.Printf("%d reads, final contents %q\n", atomic.LoadInt64(&reads), b.Data())
+}
+
+// cacheDemo requests three files and a missing one from many goroutines
+// at once. Each file is loaded once, and so is the missing file, as the
+// cache keeps the error for a second. Then a fifth file evicts the least
+// recently used entry from the cache, which holds four entries at most.
+func cacheDemo() {
+	dir, err := os.MkdirTemp("", "synconce")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+			log.Fatalln(err)
+		}
+		paths = append(paths, path)
+	}
+	extra := paths[3]
+	paths[3] = filepath.Join(dir, "missing")
+
+	c := NewFileCache(CacheOptions{TTL: time.Minute, MaxEntries: 4, ErrorTTL: time.Second})
+	// Simulate a slow file system, so that requests overlap with loads.
+	c.load = func(_ context.Context, path string) ([]byte, error) {
+		time.Sleep(10 * time.Millisecond)
+		return os.ReadFile(path)
+	}
+	printStats := func() {
+		st := c.Stats()
+		fmt.Printf("%d entries, %d hits, %d misses, %d loads, %d deduplicated, %d evictions\n",
+			c.Len(), st.Hits, st.Misses, st.Loads, st.Deduplicated, st.Evictions)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			c.Get(ctx, path) // error check omitted; the missing file is expected to fail
+		}(paths[i%len(paths)])
+	}
+	wg.Wait()
+	printStats()
+
+	fmt.Println("requesting a fifth file")
+	if _, err := c.Get(context.Background(), extra); err != nil {
+		log.Fatalln(err)
+	}
+	printStats()
+}
+
+func main() {
+	good := flag.Bool("good", false, "use GoodGetFile instead of BadGetFile")
+	n := flag.Int("n", 1000, "number of goroutines")
+	file := flag.String("file", "data", "file to load; created if it does not exist")
+	size := flag.Int("size", 3200000, "size of a created file")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+	case "retry":
+		retryDemo()
+		return
+	case "reload":
+		reloadDemo()
+		return
+	case "cache":
+		cacheDemo()
+		return
+	default:
+		log.Fatalln("unknown demo:", flag.Arg(0))
+	}
+
+	if err := writeFixture(*file, *size); err != nil {
+		log.Fatalln(err)
+	}
+	b := NewFileBuffer(*file)
+	failed := getConcurrently(b, *good, *n)
+	if failed > 0 {
+		log.Printf("error: file not loaded in %d calls", failed)
+	}
+	fmt.Printf("%d goroutines, file loaded %d times\n", *n, b.Loads())
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newFixtureBuffer returns a FileBuffer for a generated file
+// in a temporary directory.
+func newFixtureBuffer(t *testing.T) *FileBuffer {
+	t.Helper()
+	stdout = io.Discard
+	t.Cleanup(func() { stdout = os.Stdout })
+	path := filepath.Join(t.TempDir(), "data")
+	if err := writeFixture(path, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	return NewFileBuffer(path)
+}
+
+func TestWriteFixture(t *testing.T) {
+	b := newFixtureBuffer(t)
+	if n := len(b.GoodGetFile()); n != 1<<20 {
+		t.Errorf("fixture has %d bytes, want %d", n, 1<<20)
+	}
+}
+
+// Run with -race: GoodGetFile must be free of data races.
+func TestGoodGetFileLoadsOnce(t *testing.T) {
+	b := newFixtureBuffer(t)
+	if failed := getConcurrently(b, true, 1000); failed > 0 {
+		t.Errorf("%d calls got no data", failed)
+	}
+	if n := b.Loads(); n != 1 {
+		t.Errorf("file loaded %d times, want 1", n)
+	}
+}
+
+func TestGetFileLoadsOnce(t *testing.T) {
+	b := newFixtureBuffer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.GetFile(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := b.Loads(); n != 1 {
+		t.Errorf("file loaded %d times, want 1", n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// OnceValue loads a value once, like sync.Once, but the load can fail.
+//
+// sync.Once has no way to report an error: after Do returns, the function
+// counts as done, whether it succeeded or not. OnceValue caches only a
+// successful load. If the load fails, the caller gets the error, and a
+// later caller tries again, unless the backoff period of the failure is
+// still running. Within that period, callers get the error of the last
+// attempt right away, so that a broken resource is not hammered by every
+// caller.
+//
+// Only one load runs at a time. Callers that arrive during a load wait
+// for it, but only as long as their context allows.
+type OnceValue[T any] struct {
+	load    func(context.Context) (T, error)
+	backoff func(failures int) time.Duration
+
+	done  uint32        // 1 after a successful load; read without the lock
+	value T             // valid when done is 1
+	lock  chan struct{} // a mutex that a waiting caller can give up on
+
+	// Guarded by lock.
+	err      error     // error of the last attempt
+	failures int       // consecutive failures
+	retry    time.Time // earliest time of the next attempt
+}
+
+// NewOnceValue returns a OnceValue that calls load to get the value.
+// load receives the context of the caller that triggered it.
+//
+// After the n-th failure in a row, backoff(n) tells how long callers get
+// the error before the next attempt. A nil backoff retries with the next
+// call.
+func NewOnceValue[T any](load func(context.Context) (T, error), backoff func(failures int) time.Duration) *OnceValue[T] {
+	return &OnceValue[T]{
+		load:    load,
+		backoff: backoff,
+		lock:    make(chan struct{}, 1),
+	}
+}
+
+// Get returns the value, loading it if necessary.
+// It waits for a load of another caller to finish.
+func (o *OnceValue[T]) Get() (T, error) {
+	return o.GetContext(context.Background())
+}
+
+// GetContext returns the value, loading it if necessary.
+// If ctx ends while the caller waits for a load of another caller, or
+// for its own load, GetContext returns the context's error. A load that
+// fails because of the caller's context does not count as a failure,
+// as the next caller may well have more time.
+func (o *OnceValue[T]) GetContext(ctx context.Context) (T, error) {
+	var zero T
+
+	// Fast path: after a successful load, there is nothing to wait for.
+	if atomic.LoadUint32(&o.done) == 1 {
+		return o.value, nil
+	}
+
+	select {
+	case o.lock <- struct{}{}:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	defer func() { <-o.lock }()
+
+	// Another caller may have loaded the value while we were waiting.
+	if atomic.LoadUint32(&o.done) == 1 {
+		return o.value, nil
+	}
+	if o.err != nil && time.Now().Before(o.retry) {
+		return zero, o.err
+	}
+
+	v, err := o.load(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return zero, ctx.Err()
+		}
+		o.err = err
+		o.failures++
+		if o.backoff != nil {
+			o.retry = time.Now().Add(o.backoff(o.failures))
+		}
+		return zero, err
+	}
+	o.value, o.err, o.failures = v, nil, 0
+	atomic.StoreUint32(&o.done, 1)
+	return v, nil
+}
+
+// ExponentialBackoff returns a backoff function for NewOnceValue that
+// starts at base and doubles with every failure, up to max.
+func ExponentialBackoff(base, max time.Duration) func(failures int) time.Duration {
+	return func(failures int) time.Duration {
+		d := base
+		for i := 1; i < failures && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnceValueLoadsOnce(t *testing.T) {
+	var loads int32
+	o := NewOnceValue(func(context.Context) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 42, nil
+	}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := o.Get(); v != 42 || err != nil {
+				t.Errorf("Get() = %d, %v, want 42, nil", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("loaded %d times, want 1", loads)
+	}
+}
+
+func TestOnceValueRetries(t *testing.T) {
+	errBroken := errors.New("broken")
+	loads := 0
+	o := NewOnceValue(func(context.Context) (string, error) {
+		loads++
+		if loads < 3 {
+			return "", errBroken
+		}
+		return "ok", nil
+	}, nil)
+
+	for i, want := range []error{errBroken, errBroken, nil, nil} {
+		if _, err := o.Get(); err != want {
+			t.Errorf("call %d: err = %v, want %v", i, err, want)
+		}
+	}
+	if loads != 3 {
+		t.Errorf("loaded %d times, want 3", loads)
+	}
+}
+
+func TestOnceValueBackoff(t *testing.T) {
+	errBroken := errors.New("broken")
+	loads := 0
+	o := NewOnceValue(func(context.Context) (string, error) {
+		loads++
+		return "", errBroken
+	}, func(int) time.Duration { return 50 * time.Millisecond })
+
+	for i := 0; i < 5; i++ {
+		if _, err := o.Get(); err != errBroken {
+			t.Fatalf("err = %v, want %v", err, errBroken)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times during backoff, want 1", loads)
+	}
+	time.Sleep(60 * time.Millisecond)
+	o.Get()
+	if loads != 2 {
+		t.Errorf("loaded %d times after backoff, want 2", loads)
+	}
+}
+
+func TestOnceValueContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	o := NewOnceValue(func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	}, nil)
+	go o.Get()
+	<-started
+
+	// A caller that waits for the slow load gives up when its context ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := o.GetContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	if v, err := o.Get(); v != 1 || err != nil {
+		t.Errorf("Get() = %d, %v, want 1, nil", v, err)
+	}
+}
+
+func TestOnceValueCanceledLoadIsNoFailure(t *testing.T) {
+	loads := 0
+	o := NewOnceValue(func(ctx context.Context) (int, error) {
+		loads++
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}, func(int) time.Duration { return time.Hour })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := o.GetContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	// Without the backoff of a failure, the next caller loads right away.
+	if v, err := o.Get(); v != 1 || err != nil {
+		t.Errorf("Get() = %d, %v, want 1, nil", v, err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for n, want := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 10: 50} {
+		if got := b(n); got != want*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want*time.Millisecond)
+		}
+	}
+}
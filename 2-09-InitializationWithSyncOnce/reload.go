@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is one version of a file's contents.
+// A Snapshot never changes; a reload creates a new one.
+type Snapshot struct {
+	Data    []byte
+	Hash    [sha256.Size]byte
+	ModTime time.Time
+	Size    int64
+	Version int // 1 for the first load, incremented with every reload
+}
+
+// A ReloadEvent tells subscribers that the file was reloaded,
+// or that a reload failed.
+type ReloadEvent struct {
+	Version int   // version of the current contents
+	Err     error // if not nil, the reload failed, and the old contents stay
+}
+
+func (e ReloadEvent) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("reload failed, keeping version %d: %s", e.Version, e.Err)
+	}
+	return fmt.Sprintf("reloaded, now at version %d", e.Version)
+}
+
+// ReloadingBuffer keeps a file's contents in memory, like FileBuffer, but
+// picks up changes to the file, as a service would do with its config.
+//
+// Watch polls the file's modification time and size. If either changed,
+// it reads the file, and if the hash of the contents changed, too, it
+// replaces the snapshot. A touched but unchanged file is not a reload.
+// Reload rereads the file right away, for changes that polling cannot
+// see: a write of the same size within the timestamp resolution of
+// the file system.
+//
+// Readers never block: Data and Snapshot load the current snapshot
+// atomically, and a reload swaps in a complete new snapshot. A reader
+// that holds on to a snapshot keeps a consistent, if outdated, view.
+type ReloadingBuffer struct {
+	path string
+	cur  atomic.Value // *Snapshot
+
+	reload  sync.Mutex // serializes reloads
+	lastErr string     // error of the last reload, to report a failure once
+
+	mu     sync.Mutex
+	subs   map[int]chan ReloadEvent
+	nextID int
+}
+
+// NewReloadingBuffer loads the file at path. It fails if the file
+// cannot be read; a ReloadingBuffer always has contents.
+func NewReloadingBuffer(path string) (*ReloadingBuffer, error) {
+	b := &ReloadingBuffer{path: path, subs: map[int]chan ReloadEvent{}}
+	s, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	s.Version = 1
+	b.cur.Store(s)
+	return b, nil
+}
+
+// Data returns the current contents of the file.
+// The caller must not modify the slice.
+func (b *ReloadingBuffer) Data() []byte {
+	return b.Snapshot().Data
+}
+
+// Snapshot returns the current version of the file.
+func (b *ReloadingBuffer) Snapshot() *Snapshot {
+	return b.cur.Load().(*Snapshot)
+}
+
+// Subscribe returns a channel that receives an event after every reload
+// and every failed reload, and a function that ends the subscription.
+//
+// A slow subscriber does not hold up reloads. The channel holds only
+// the latest event; an event that the subscriber has not received yet
+// is replaced by the next one. For a config reload, only the latest
+// version matters anyway.
+func (b *ReloadingBuffer) Subscribe() (<-chan ReloadEvent, func()) {
+	ch := make(chan ReloadEvent, 1)
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = ch
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}
+}
+
+// Watch polls the file every interval, and reloads it if it changed,
+// until ctx is canceled.
+func (b *ReloadingBuffer) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.check(false)
+		}
+	}
+}
+
+// Reload rereads the file, even if its modification time and size are
+// unchanged, and swaps in the new contents if they differ. It returns
+// the error of a failed reload; the old contents stay.
+func (b *ReloadingBuffer) Reload() error {
+	return b.check(true)
+}
+
+// check reloads the file if its modification time or size changed,
+// or unconditionally if force is set.
+func (b *ReloadingBuffer) check(force bool) error {
+	b.reload.Lock()
+	defer b.reload.Unlock()
+
+	old := b.Snapshot()
+	if !force {
+		fi, err := os.Stat(b.path)
+		if err != nil {
+			b.failed(old, err)
+			return err
+		}
+		if fi.ModTime().Equal(old.ModTime) && fi.Size() == old.Size {
+			return nil
+		}
+	}
+	s, err := b.read()
+	if err != nil {
+		b.failed(old, err)
+		return err
+	}
+	b.lastErr = ""
+	if s.Hash == old.Hash {
+		// Same contents. Remember the new modification time,
+		// so that the next poll does not read the file again.
+		s.Data, s.Version = old.Data, old.Version
+		b.cur.Store(s)
+		return nil
+	}
+	s.Version = old.Version + 1
+	b.cur.Store(s)
+	b.notify(ReloadEvent{Version: s.Version})
+	return nil
+}
+
+// failed reports a failed reload, unless the same error was reported
+// last time. A removed file would otherwise cause an event per poll.
+func (b *ReloadingBuffer) failed(cur *Snapshot, err error) {
+	if err.Error() == b.lastErr {
+		return
+	}
+	b.lastErr = err.Error()
+	b.notify(ReloadEvent{Version: cur.Version, Err: err})
+}
+
+// read reads the file into a new snapshot without a version.
+// It takes the file info before reading, so that a write during the
+// read makes the next poll read the file again.
+func (b *ReloadingBuffer) read() (*Snapshot, error) {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Data:    data,
+		Hash:    sha256.Sum256(data),
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+	}, nil
+}
+
+// notify sends e to all subscribers, replacing an event
+// that a subscriber has not received yet.
+func (b *ReloadingBuffer) notify(e ReloadEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- e // cannot block: check is the only sender, and ch is empty
+	}
+}
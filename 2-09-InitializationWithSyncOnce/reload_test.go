@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBuffer returns a ReloadingBuffer for a temporary file
+// with the given contents.
+func newTestBuffer(t *testing.T, contents string) (*ReloadingBuffer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	writeFile(t, path, contents)
+	b, err := NewReloadingBuffer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, path
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitEvent returns the next event, or fails the test after a second.
+func waitEvent(t *testing.T, events <-chan ReloadEvent) ReloadEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no reload event")
+		return ReloadEvent{}
+	}
+}
+
+func TestReloadingBufferWatch(t *testing.T) {
+	b, path := newTestBuffer(t, "a")
+	events, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Watch(ctx, 5*time.Millisecond)
+
+	writeFile(t, path, "bb")
+	if e := waitEvent(t, events); e.Version != 2 || e.Err != nil {
+		t.Errorf("event = %+v, want version 2", e)
+	}
+	if got := string(b.Data()); got != "bb" {
+		t.Errorf("Data() = %q, want %q", got, "bb")
+	}
+
+	os.Remove(path)
+	if e := waitEvent(t, events); e.Version != 2 || e.Err == nil {
+		t.Errorf("event = %+v, want an error at version 2", e)
+	}
+	if got := string(b.Data()); got != "bb" {
+		t.Errorf("Data() after failed reload = %q, want %q", got, "bb")
+	}
+	// The same error is reported once.
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReloadingBufferUnchangedContents(t *testing.T) {
+	b, path := newTestBuffer(t, "a")
+	events, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.check(false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("touching the file caused event %+v", e)
+	default:
+	}
+	if s := b.Snapshot(); s.Version != 1 || !s.ModTime.Equal(later) {
+		t.Errorf("snapshot = version %d, mod time %v, want version 1, mod time %v", s.Version, s.ModTime, later)
+	}
+}
+
+func TestReloadingBufferForcedReload(t *testing.T) {
+	b, path := newTestBuffer(t, "a")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same size, same modification time: invisible to polling.
+	writeFile(t, path, "b")
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	b.check(false)
+	if got := string(b.Data()); got != "a" {
+		t.Fatalf("polling saw the change: Data() = %q", got)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b.Data()); got != "b" {
+		t.Errorf("Data() after Reload = %q, want %q", got, "b")
+	}
+}
+
+func TestReloadingBufferConcurrentReaders(t *testing.T) {
+	b, path := newTestBuffer(t, "v0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Watch(ctx, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				// A snapshot is consistent: its hash matches its data.
+				s := b.Snapshot()
+				if sha256.Sum256(s.Data) != s.Hash {
+					t.Errorf("snapshot %d: hash does not match data %q", s.Version, s.Data)
+					return
+				}
+			}
+		}()
+	}
+	for _, v := range []string{"v1", "v2"} {
+		time.Sleep(10 * time.Millisecond)
+		writeFile(t, path, v)
+	}
+	// The busy readers must not keep the watcher from reloading.
+	deadline := time.Now().Add(time.Second)
+	for string(b.Data()) != "v2" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	if got := string(b.Data()); got != "v2" {
+		t.Errorf("Data() = %q, want %q", got, "v2")
+	}
+}
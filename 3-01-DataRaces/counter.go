@@ -0,0 +1,103 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// cellSize is the size of a counter cell. A cache line has 64 bytes on
+// most CPUs, but Intel CPUs fetch cache lines in pairs, and Apple's ARM
+// CPUs have 128-byte lines. Two cells on the same line would bring back
+// the contention that sharding is meant to avoid ("false sharing").
+const cellSize = 128
+
+// cell is one shard of a Counter, padded to fill its own cache line(s).
+type cell struct {
+	n int64
+	_ [cellSize - 8]byte
+}
+
+// Counter is a counter for many goroutines that increment it at the same
+// time, like a request counter of a busy server.
+//
+// With atomic.AddInt64 on a single variable, all CPUs fight over the
+// cache line that holds the variable, and each increment has to wait
+// for the line to move to its CPU. Counter spreads the increments over
+// several cells, each on its own cache line, so that goroutines on
+// different CPUs rarely touch the same cell. Reading the value sums up
+// all cells, so reads are slower than with a single variable, and a
+// read during increments is not a snapshot of a single moment. This is
+// a good trade-off for counters that are written far more often than
+// they are read, like statistics.
+//
+// The zero value is not usable; create a Counter with NewCounter.
+type Counter struct {
+	cells []cell
+	mask  uint32
+}
+
+// A token is a goroutine's hint which cell to use.
+//
+// Tokens live in a sync.Pool, which keeps a cache per P (the runtime's
+// handle for a CPU that runs goroutines). Hence goroutines that run on
+// the same P mostly get the same token, and goroutines on different Ps
+// get different tokens, and different cells. Go has no API to ask for
+// the current P, and this is the closest approximation.
+type token struct {
+	idx uint32
+}
+
+var (
+	nextToken uint32 // to spread new tokens over the cells
+	tokens    = sync.Pool{
+		New: func() interface{} {
+			// Multiply by a large odd number to scatter the indexes.
+			return &token{idx: atomic.AddUint32(&nextToken, 1) * 0x9e3779b9}
+		},
+	}
+)
+
+// NewCounter returns a counter with the given number of cells, rounded
+// up to a power of two. If cells is zero or less, the counter uses four
+// cells per CPU that the runtime may use (GOMAXPROCS).
+func NewCounter(cells int) *Counter {
+	if cells <= 0 {
+		cells = 4 * runtime.GOMAXPROCS(0)
+	}
+	n := 1
+	for n < cells {
+		n *= 2
+	}
+	return &Counter{cells: make([]cell, n), mask: uint32(n - 1)}
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta int64) {
+	t := tokens.Get().(*token)
+	cl := &c.cells[t.idx&c.mask]
+	old := atomic.LoadInt64(&cl.n)
+	if !atomic.CompareAndSwapInt64(&cl.n, old, old+delta) {
+		// Another goroutine uses this cell at the same time.
+		// Complete the increment, and move to another cell next time.
+		atomic.AddInt64(&cl.n, delta)
+		t.idx ^= t.idx << 13
+		t.idx ^= t.idx >> 17
+		t.idx ^= t.idx << 5
+	}
+	tokens.Put(t)
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the sum of all cells.
+func (c *Counter) Value() int64 {
+	var sum int64
+	for i := range c.cells {
+		sum += atomic.LoadInt64(&c.cells[i].n)
+	}
+	return sum
+}
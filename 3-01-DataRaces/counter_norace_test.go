@@ -0,0 +1,16 @@
+//go:build !race
+// +build !race
+
+package main
+
+import "testing"
+
+// The racy counter is not a counter at all: it loses increments.
+// It is here only to show the cost of an increment without any
+// synchronization. The race detector would rightly reject it.
+func BenchmarkRacyCounter(b *testing.B) {
+	var counter int64
+	benchIncrement(b, func() {
+		counter++
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc()
+			}
+			c.Add(-500)
+		}()
+	}
+	wg.Wait()
+	if got, want := c.Value(), int64(50*500); got != want {
+		t.Errorf("Value() = %d, want %d", got, want)
+	}
+}
+
+func TestNewCounterCells(t *testing.T) {
+	for cells, want := range map[int]int{1: 1, 3: 4, 8: 8, 9: 16} {
+		if got := len(NewCounter(cells).cells); got != want {
+			t.Errorf("NewCounter(%d) has %d cells, want %d", cells, got, want)
+		}
+	}
+	if n := len(NewCounter(0).cells); n == 0 || n&(n-1) != 0 {
+		t.Errorf("NewCounter(0) has %d cells, want a power of two", n)
+	}
+}
+
+// benchGoroutines are the numbers of goroutines that increment
+// the counter at the same time. Contention needs several CPUs, too:
+// run the benchmarks with, for example, -cpu 1,4,16. On a single CPU,
+// the sharded counter only adds overhead.
+var benchGoroutines = []int{1, 2, 4, 8, 16, 64}
+
+// benchIncrement runs b.N increments of inc, spread over
+// goroutines, for each number of goroutines.
+func benchIncrement(b *testing.B, inc func()) {
+	for _, g := range benchGoroutines {
+		b.Run(fmt.Sprintf("goroutines=%d", g), func(b *testing.B) {
+			var wg sync.WaitGroup
+			wg.Add(g)
+			for i := 0; i < g; i++ {
+				n := b.N / g
+				if i < b.N%g {
+					n++
+				}
+				go func() {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						inc()
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkMutexCounter(b *testing.B) {
+	var m sync.Mutex
+	var counter int64
+	benchIncrement(b, func() {
+		m.Lock()
+		counter++
+		m.Unlock()
+	})
+}
+
+func BenchmarkAtomicCounter(b *testing.B) {
+	var counter int64
+	benchIncrement(b, func() {
+		atomic.AddInt64(&counter, 1)
+	})
+}
+
+func BenchmarkShardedCounter(b *testing.B) {
+	c := NewCounter(0)
+	benchIncrement(b, c.Inc)
+}
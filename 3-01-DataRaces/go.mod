@@ -1,3 +1,3 @@
 module github.com/AppliedGoCourses/ConcurrencyDeepDive/3-01-DataRaces/dataraces
 
-go 1.16
+go 1.19
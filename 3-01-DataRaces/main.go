@@ -2,20 +2,30 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-func race(n int) {
+// Used for muting output during tests.
+var stdout io.Writer = os.Stdout
+
+// The counter functions below start n goroutines that increment a shared
+// counter the given number of times each, and return the final value.
+// It should be n * iterations.
+
+func race(n, iterations int) int64 {
 	// This counter is going to be used by multiple goroutines - data race ahead!
-	var counter int
+	var counter int64
 
 	wg := &sync.WaitGroup{}
 
-	// This is our goroutine code. It initialized the counter if it is still nil,
-	// and then increments it 10 times.
+	// This is our goroutine code. It increments the counter
+	// iterations times.
 	count := func(wg *sync.WaitGroup) {
-		for i := 0; i < 1000; i++ {
+		for i := 0; i < iterations; i++ {
 			counter++
 		}
 		wg.Done()
@@ -28,11 +38,11 @@ func race(n int) {
 	}
 	wg.Wait()
 
-	// The counter's final value should now be n * 10.
-	fmt.Println("Final value:", counter)
+	// The counter's final value should now be n * iterations.
+	return counter
 }
 
-func mutex(n int) {
+func mutex(n, iterations int) int64 {
 	var counter int64
 
 	// A mutex guards the execution of statements.
@@ -42,7 +52,7 @@ func mutex(n int) {
 
 	count := func(wg *sync.WaitGroup) {
 
-		for i := 0; i < 100; i++ {
+		for i := 0; i < iterations; i++ {
 			// A goroutine that locks a mutex has exclusive access
 			// to shared data.
 			// A goroutine that tries to lock an already locked mutex
@@ -63,17 +73,17 @@ func mutex(n int) {
 		go count(wg)
 	}
 	wg.Wait()
-	fmt.Println("Final value:", counter)
+	return counter
 }
 
-func atomicInc(n int) {
+func atomicInc(n, iterations int) int64 {
 	var counter int64
 
 	wg := &sync.WaitGroup{}
 
 	count := func(wg *sync.WaitGroup) {
 
-		for i := 0; i < 100; i++ {
+		for i := 0; i < iterations; i++ {
 
 			// atomic contains some primitives for atomically handling
 			// integer operations like add or swap.
@@ -90,10 +100,34 @@ func atomicInc(n int) {
 		go count(wg)
 	}
 	wg.Wait()
-	fmt.Println("Final value:", counter)
+	return counter
+}
+
+func sharded(n, iterations int) int64 {
+	// A Counter spreads the increments over several padded cells, so that
+	// goroutines on different CPUs do not fight over the same cache line.
+	counter := NewCounter(0)
+
+	wg := &sync.WaitGroup{}
+
+	count := func(wg *sync.WaitGroup) {
+		for i := 0; i < iterations; i++ {
+			counter.Inc()
+		}
+		wg.Done()
+	}
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go count(wg)
+	}
+	wg.Wait()
+	return counter.Value()
 }
 
-func once(n int) {
+// once starts n goroutines that set up a map once, and then store
+// a value each. It returns the map, which should have n entries.
+func once(n int) map[string]int {
 	var data map[string]int
 	var m sync.Mutex
 	var wg sync.WaitGroup
@@ -101,14 +135,14 @@ func once(n int) {
 	var once sync.Once
 
 	setup := func() {
-		fmt.Println("In setup()")
+		fmt.Fprintln(stdout, "In setup()")
 		data = make(map[string]int, n)
 	}
 
 	worker := func(s string, n int, wg *sync.WaitGroup) {
 		// No matter how many goroutines call this,
 		// it only gets executed once.
-		fmt.Println("Calling once.Do(setup)")
+		fmt.Fprintln(stdout, "Calling once.Do(setup)")
 		once.Do(setup)
 
 		m.Lock()
@@ -124,26 +158,58 @@ func once(n int) {
 	}
 
 	wg.Wait()
-	fmt.Printf("Map 'data': %v\n", data)
+	return data
+}
+
+// lostUpdates calls race the given number of times, and returns the
+// number of runs that lost updates, and the number of updates lost in
+// total.
+func lostUpdates(runs, n, iterations int) (lossy int, lost int64) {
+	want := int64(n * iterations)
+	for i := 0; i < runs; i++ {
+		if got := race(n, iterations); got != want {
+			lossy++
+			lost += want - got
+		}
+	}
+	return lossy, lost
 }
 
 func main() {
 
 	fmt.Println("*** Data race ***")
 	for i := 0; i < 10; i++ {
-		race(10)
+		fmt.Println("Final value:", race(10, 1000))
 	}
+	lossy, lost := lostUpdates(100, 10, 1000)
+	fmt.Printf("Lost updates in %d of 100 runs, %d updates lost in total\n", lossy, lost)
 
 	fmt.Println("\n*** Mutex ***")
 	for i := 0; i < 10; i++ {
-		mutex(10)
+		fmt.Println("Final value:", mutex(10, 100))
 	}
 
 	fmt.Println("\n*** Atomic ***")
 	for i := 0; i < 10; i++ {
-		atomicInc(10)
+		fmt.Println("Final value:", atomicInc(10, 100))
+	}
+
+	fmt.Println("\n*** Sharded counter ***")
+	for i := 0; i < 10; i++ {
+		fmt.Println("Final value:", sharded(10, 100))
+	}
+
+	fmt.Println("\n*** Read-mostly map ***")
+	keys := mapKeys(1000)
+	for _, writePercent := range []int{0, 1, 10} {
+		for _, m := range maps {
+			mp := fillMap(m.new(), keys)
+			start := time.Now()
+			readMostly(mp, keys, 10, 10000, writePercent)
+			fmt.Printf("%2d%% writes, %-8s %v\n", writePercent, m.name, time.Since(start).Round(time.Millisecond))
+		}
 	}
 
 	fmt.Println("\n*** Once ***")
-	once(10)
+	fmt.Printf("Map 'data': %v\n", once(10))
 }
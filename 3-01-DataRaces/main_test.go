@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	stdout = io.Discard
+	os.Exit(m.Run())
+}
+
+// safeCounters are the counter functions that must not lose updates.
+var safeCounters = []struct {
+	name  string
+	count func(n, iterations int) int64
+}{
+	{"mutex", mutex},
+	{"atomic", atomicInc},
+	{"sharded", sharded},
+}
+
+func TestSafeCounters(t *testing.T) {
+	for _, c := range safeCounters {
+		for _, n := range []int{1, 10, 100} {
+			for _, iterations := range []int{1, 100, 1000} {
+				name := fmt.Sprintf("%s/n=%d/iterations=%d", c.name, n, iterations)
+				t.Run(name, func(t *testing.T) {
+					if got, want := c.count(n, iterations), int64(n*iterations); got != want {
+						t.Errorf("final value %d, want %d", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestOnce(t *testing.T) {
+	for _, n := range []int{1, 10, 100} {
+		// If setup ran more than once, it would replace
+		// the map and lose entries.
+		data := once(n)
+		if len(data) != n {
+			t.Errorf("once(%d): map has %d entries, want %d", n, len(data), n)
+		}
+		for i := 0; i < n; i++ {
+			if v := data[fmt.Sprintf("go%d", i)]; v != i*i {
+				t.Errorf("once(%d): go%d = %d, want %d", n, i, v, i*i)
+			}
+		}
+	}
+}
+
+func BenchmarkSafeCounters(b *testing.B) {
+	for _, c := range safeCounters {
+		for _, n := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("%s/n=%d", c.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					c.count(n, 1000)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkOnce(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		once(100)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build race
+// +build race
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestRaceDetected verifies that the race detector finds the data race
+// in race. A data race fails the test that causes it, so the racy code
+// runs in a child process: the test binary runs itself, with an
+// environment variable that makes this test call race.
+func TestRaceDetected(t *testing.T) {
+	if os.Getenv("DATARACES_RUN_RACE") == "1" {
+		race(2, 1000)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRaceDetected$", "-test.count=1")
+	cmd.Env = append(os.Environ(), "DATARACES_RUN_RACE=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("the child process succeeded, want a failure by the race detector:\n%s", out)
+	}
+	if !strings.Contains(string(out), "WARNING: DATA RACE") {
+		t.Fatalf("the race detector did not report the race:\n%s", out)
+	}
+}
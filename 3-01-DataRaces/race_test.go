@@ -0,0 +1,24 @@
+//go:build !race
+// +build !race
+
+package main
+
+import "testing"
+
+// TestLostUpdates runs the racy counter many times and reports how often
+// it lost updates. It does not fail: whether updates get lost depends on
+// the number of CPUs and on the scheduler. On a single CPU, the race may
+// never show. The race is there anyway, as TestRaceDetected shows when
+// run with -race.
+func TestLostUpdates(t *testing.T) {
+	const runs, n, iterations = 200, 10, 1000
+	lossy, lost := lostUpdates(runs, n, iterations)
+	t.Logf("lost updates in %d of %d runs, %d of %d updates lost in total",
+		lossy, runs, lost, runs*n*iterations)
+}
+
+func BenchmarkRace(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		race(10, 1000)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Map is a map from strings to ints that is safe for concurrent use.
+// The implementations below differ in how they keep it safe, which
+// matters when the map is read far more often than written, as a cache.
+type Map interface {
+	Load(key string) (int, bool)
+	Store(key string, value int)
+}
+
+// MutexMap guards a map with a sync.Mutex. Readers exclude each other,
+// too, although they could safely read at the same time.
+type MutexMap struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func NewMutexMap() *MutexMap {
+	return &MutexMap{m: map[string]int{}}
+}
+
+func (m *MutexMap) Load(key string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.m[key]
+	return v, ok
+}
+
+func (m *MutexMap) Store(key string, value int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m[key] = value
+}
+
+// RWMutexMap guards a map with a sync.RWMutex, so that readers can read
+// at the same time. A writer still excludes everyone else. Note that
+// RLock writes to the mutex, too: with many CPUs, the readers contend
+// for the cache line of the reader count.
+type RWMutexMap struct {
+	mu sync.RWMutex
+	m  map[string]int
+}
+
+func NewRWMutexMap() *RWMutexMap {
+	return &RWMutexMap{m: map[string]int{}}
+}
+
+func (m *RWMutexMap) Load(key string) (int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.m[key]
+	return v, ok
+}
+
+func (m *RWMutexMap) Store(key string, value int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m[key] = value
+}
+
+// SyncMap uses a sync.Map, which is optimized for keys that are written
+// once and read many times, and for goroutines that work on disjoint
+// sets of keys. Its values are interface{}, which costs an allocation
+// per stored int.
+type SyncMap struct {
+	m sync.Map
+}
+
+func NewSyncMap() *SyncMap {
+	return &SyncMap{}
+}
+
+func (m *SyncMap) Load(key string) (int, bool) {
+	v, ok := m.m.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return v.(int), true
+}
+
+func (m *SyncMap) Store(key string, value int) {
+	m.m.Store(key, value)
+}
+
+// COWMap is a copy-on-write map. Readers load the current map through
+// an atomic pointer, and read it without any lock, as nobody ever
+// modifies it. A writer copies the map, modifies the copy, and swaps
+// the pointer. Reads are as fast as they get, but every write copies
+// the whole map, so writes must be rare, or the map small.
+type COWMap struct {
+	mu sync.Mutex // serializes writers, so that no write gets lost
+	m  atomic.Pointer[map[string]int]
+}
+
+func NewCOWMap() *COWMap {
+	m := &COWMap{}
+	m.m.Store(&map[string]int{})
+	return m
+}
+
+func (m *COWMap) Load(key string) (int, bool) {
+	v, ok := (*m.m.Load())[key]
+	return v, ok
+}
+
+func (m *COWMap) Store(key string, value int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := *m.m.Load()
+	next := make(map[string]int, len(old)+1)
+	for k, v := range old {
+		next[k] = v
+	}
+	next[key] = value
+	m.m.Store(&next)
+}
+
+// maps are the Map implementations to compare.
+var maps = []struct {
+	name string
+	new  func() Map
+}{
+	{"Mutex", func() Map { return NewMutexMap() }},
+	{"RWMutex", func() Map { return NewRWMutexMap() }},
+	{"sync.Map", func() Map { return NewSyncMap() }},
+	{"COW", func() Map { return NewCOWMap() }},
+}
+
+// mapKeys returns the keys of the read-mostly scenario.
+func mapKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// fillMap stores the keys in m, as a cache that is filled at startup.
+func fillMap(m Map, keys []string) Map {
+	for i, k := range keys {
+		m.Store(k, i)
+	}
+	return m
+}
+
+// readMostly starts n goroutines that access the keys of a filled map
+// ops times each. writePercent of the accesses are writes, which update
+// existing keys, and the others are reads. It returns the number of
+// reads that found their key, which should be all of them.
+func readMostly(m Map, keys []string, n, ops, writePercent int) int64 {
+	var found int64
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for g := 0; g < n; g++ {
+		go func(g int) {
+			defer wg.Done()
+			var hits int64
+			for i := 0; i < ops; i++ {
+				// Each goroutine starts at a different key.
+				k := keys[(g*7919+i)%len(keys)]
+				if i%100 < writePercent {
+					m.Store(k, i)
+					continue
+				}
+				if _, ok := m.Load(k); ok {
+					hits++
+				}
+			}
+			atomic.AddInt64(&found, hits)
+		}(g)
+	}
+	wg.Wait()
+	return found
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMaps(t *testing.T) {
+	for _, m := range maps {
+		t.Run(m.name, func(t *testing.T) {
+			mp := m.new()
+			if _, ok := mp.Load("a"); ok {
+				t.Error("empty map has key a")
+			}
+			mp.Store("a", 1)
+			mp.Store("b", 2)
+			mp.Store("a", 3)
+			for k, want := range map[string]int{"a": 3, "b": 2} {
+				if v, ok := mp.Load(k); !ok || v != want {
+					t.Errorf("Load(%q) = %d, %v, want %d, true", k, v, ok, want)
+				}
+			}
+		})
+	}
+}
+
+// TestMapsConcurrent writes disjoint keys from several goroutines while
+// others read. Run with -race. No write may get lost; for the COW map,
+// this depends on the writers' mutex.
+func TestMapsConcurrent(t *testing.T) {
+	const writers, readers, keys = 4, 4, 200
+	for _, m := range maps {
+		t.Run(m.name, func(t *testing.T) {
+			mp := m.new()
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < keys; i++ {
+						mp.Store(strconv.Itoa(w)+"-"+strconv.Itoa(i), i)
+					}
+				}(w)
+			}
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					for i := 0; i < keys; i++ {
+						// A key that exists has the value that was written.
+						if v, ok := mp.Load(strconv.Itoa(r%writers) + "-" + strconv.Itoa(i)); ok && v != i {
+							t.Errorf("read %d, want %d", v, i)
+						}
+					}
+				}(r)
+			}
+			wg.Wait()
+			for w := 0; w < writers; w++ {
+				for i := 0; i < keys; i++ {
+					if v, ok := mp.Load(strconv.Itoa(w) + "-" + strconv.Itoa(i)); !ok || v != i {
+						t.Fatalf("key %d-%d: %d, %v, want %d, true", w, i, v, ok, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadMostly(t *testing.T) {
+	const n, ops = 8, 1000
+	keys := mapKeys(100)
+	for _, m := range maps {
+		for _, writePercent := range []int{0, 1, 10, 100} {
+			t.Run(fmt.Sprintf("%s/writes=%d%%", m.name, writePercent), func(t *testing.T) {
+				found := readMostly(fillMap(m.new(), keys), keys, n, ops, writePercent)
+				reads := int64(n * ops * (100 - writePercent) / 100)
+				if found != reads {
+					t.Errorf("%d reads found their key, want %d", found, reads)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkReadMostly compares the maps for several write ratios.
+// Each operation is one access by one of 8 goroutines. As with the
+// counters, the differences show with several CPUs: run with -cpu.
+func BenchmarkReadMostly(b *testing.B) {
+	const goroutines = 8
+	keys := mapKeys(1000)
+	for _, writePercent := range []int{0, 1, 10, 50} {
+		for _, m := range maps {
+			b.Run(fmt.Sprintf("writes=%d%%/%s", writePercent, m.name), func(b *testing.B) {
+				mp := fillMap(m.new(), keys)
+				b.ResetTimer()
+				readMostly(mp, keys, goroutines, b.N/goroutines+1, writePercent)
+			})
+		}
+	}
+}
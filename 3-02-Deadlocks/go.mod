@@ -1,3 +1,7 @@
 module github.com/AppliedGoCourses/ConcurrencyDeepDive/3-02-Deadlocks
 
-go 1.17
+go 1.19
+
+require github.com/AppliedGoCourses/ConcurrencyDeepDive/lockorder v0.0.0
+
+replace github.com/AppliedGoCourses/ConcurrencyDeepDive/lockorder => ../lockorder
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"flag"
 	"log"
-	"sync"
 	"time"
+
+	"github.com/AppliedGoCourses/ConcurrencyDeepDive/lockorder"
 )
 
 // A contrived file type
 type File struct {
 	path string
 	data [10]byte
-	mu   sync.Mutex
+	// A lockorder.Mutex is a sync.Mutex that reports a lock order
+	// inversion the first time it happens, before the program hangs.
+	mu lockorder.Mutex
+}
+
+func newFile(path string) *File {
+	f := &File{path: path}
+	f.mu.Name = path
+	return f
 }
 
 func copyFile(task string, source, target *File) {
@@ -34,8 +44,20 @@ func copyFile(task string, source, target *File) {
 }
 
 func main() {
-	orig := &File{path: "original"}
-	bck := &File{path: "backup"}
+	sequential := flag.Bool("sequential", false, "run backup and restore one after the other, which cannot deadlock")
+	flag.Parse()
+
+	orig := newFile("original")
+	bck := newFile("backup")
+
+	if *sequential {
+		// No deadlock this time, but the lock order inversion
+		// is a bug nonetheless, and the mutex reports it.
+		copyFile("backup", orig, bck)
+		copyFile("restore", bck, orig)
+		return
+	}
+
 	done := make(chan struct{})
 
 	go func() {
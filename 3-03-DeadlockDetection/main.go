@@ -1,24 +1,28 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
-	"sync"
 	"time"
 )
 
 type File struct {
 	path string
 	data [10]byte
-	mu   sync.Mutex
+	mu   tracedMutex // tells the watchdog who holds and who waits for the file
+}
+
+func newFile(path string) *File {
+	return &File{path: path, mu: tracedMutex{name: path}}
 }
 
 func copyFile(task string, source, target *File) {
-	source.mu.Lock()
+	source.mu.Lock(task)
 	<-time.After(time.Millisecond * 100) // provoke a deadlock situation
-	target.mu.Lock()
+	target.mu.Lock(task)
 
 	copy(target.data[:], source.data[:])
 
@@ -32,8 +36,15 @@ func main() {
 	}()
 	fmt.Println("Run\ncurl \"http://localhost:7070/debug/pprof/goroutine?debug=2\"\nto get a stack dump")
 
-	orig := &File{path: "original"}
-	bck := &File{path: "backup"}
+	// The HTTP server waits for requests, so Go's deadlock detection
+	// does not fire: not all goroutines are asleep. The watchdog finds
+	// the deadlocked goroutines anyway, and the server keeps running.
+	go watchdog.Run(context.Background(), 100*time.Millisecond, func(d *Deadlock) {
+		log.Println(d)
+	})
+
+	orig := newFile("original")
+	bck := newFile("backup")
 	done := make(chan struct{})
 
 	go func() {
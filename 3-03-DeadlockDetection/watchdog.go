@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracedMutex is a mutex that tells the watchdog who holds it, and who
+// waits for it. The zero value is an unlocked mutex.
+type tracedMutex struct {
+	mu   sync.Mutex
+	name string // for reports; the file path
+}
+
+// Lock locks m on behalf of task. The task name only shows up in reports.
+func (m *tracedMutex) Lock(task string) {
+	w := &waiter{
+		task:  task,
+		gid:   goid(),
+		stack: stack(),
+		since: time.Now(),
+	}
+	watchdog.waitFor(m, w)
+	m.mu.Lock()
+	watchdog.acquired(m, w)
+}
+
+func (m *tracedMutex) Unlock() {
+	watchdog.released(m)
+	m.mu.Unlock()
+}
+
+// A waiter is a goroutine that waits for a lock, or holds it.
+type waiter struct {
+	task  string
+	gid   int64
+	stack string    // where the goroutine called Lock
+	since time.Time // when the goroutine called Lock
+}
+
+// A Wait is one goroutine in a deadlock: it holds a lock, and waits
+// for the lock that the next goroutine in the cycle holds.
+type Wait struct {
+	Task      string
+	Goroutine int64
+	Holds     string // name of the lock the goroutine holds
+	WaitsFor  string // name of the lock the goroutine waits for
+	Since     time.Duration
+	Stack     string // stack of the goroutine when it called Lock
+}
+
+// A Deadlock is a cycle of goroutines that wait for each other.
+// The last one waits for the lock that the first one holds.
+type Deadlock struct {
+	Cycle []Wait
+}
+
+func (d *Deadlock) String() string {
+	var b strings.Builder
+	b.WriteString("deadlock detected:\n")
+	for _, w := range d.Cycle {
+		fmt.Fprintf(&b, "  task %s (goroutine %d) holds %s and waits for %s since %v\n",
+			w.Task, w.Goroutine, w.Holds, w.WaitsFor, w.Since.Round(time.Millisecond))
+	}
+	for _, w := range d.Cycle {
+		fmt.Fprintf(&b, "\ntask %s waits at:\n%s", w.Task, w.Stack)
+	}
+	return b.String()
+}
+
+// Watchdog keeps the wait-for graph of the traced mutexes: which
+// goroutine holds which lock, and which lock each blocked goroutine waits
+// for. Go's own deadlock detection only fires if all goroutines are
+// asleep; a single goroutine that waits for network input, like the
+// pprof server in main, keeps it from firing. The watchdog finds a cycle
+// of goroutines that wait for each other, no matter what the other
+// goroutines do.
+type Watchdog struct {
+	mu       sync.Mutex
+	holders  map[*tracedMutex]*waiter // who holds each lock
+	waiting  map[int64]blocked        // blocked goroutines, by ID
+	reported map[string]bool          // cycles reported, by key
+}
+
+// blocked is a goroutine that waits for a lock.
+type blocked struct {
+	m *tracedMutex
+	w *waiter
+}
+
+// watchdog is the watchdog of all traced mutexes.
+var watchdog = &Watchdog{
+	holders:  map[*tracedMutex]*waiter{},
+	waiting:  map[int64]blocked{},
+	reported: map[string]bool{},
+}
+
+func (wd *Watchdog) waitFor(m *tracedMutex, w *waiter) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.waiting[w.gid] = blocked{m, w}
+}
+
+func (wd *Watchdog) acquired(m *tracedMutex, w *waiter) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	delete(wd.waiting, w.gid)
+	wd.holders[m] = w
+}
+
+// released forgets the holder of m. As with sync.Mutex, the goroutine
+// that unlocks m need not be the one that locked it.
+func (wd *Watchdog) released(m *tracedMutex) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	delete(wd.holders, m)
+}
+
+// Run checks the wait-for graph every interval, and calls report for
+// each deadlock it finds, until ctx is canceled. A deadlock is reported
+// once; the goroutines in it stay blocked, but the rest of the program
+// keeps running.
+func (wd *Watchdog) Run(ctx context.Context, interval time.Duration, report func(*Deadlock)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, d := range wd.check() {
+				report(d)
+			}
+		}
+	}
+}
+
+// check returns the deadlocks that were not reported yet.
+//
+// Each blocked goroutine waits for one lock, and each lock has at most
+// one holder, so each goroutine has at most one outgoing edge in the
+// wait-for graph: goroutine -> the lock it waits for -> its holder.
+// Following the edges from a blocked goroutine either ends at a
+// goroutine that runs, or runs into a cycle.
+func (wd *Watchdog) check() []*Deadlock {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	now := time.Now()
+	var found []*Deadlock
+	gids := make([]int64, 0, len(wd.waiting))
+	for gid := range wd.waiting {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+
+	for _, start := range gids {
+		// Walk the edges, and remember the position of each goroutine
+		// on the path, to find where a cycle starts.
+		pos := map[int64]int{}
+		var path []int64
+		gid := start
+		for {
+			if i, ok := pos[gid]; ok {
+				cycle := path[i:]
+				if key := cycleKey(cycle); !wd.reported[key] {
+					wd.reported[key] = true
+					found = append(found, wd.deadlock(cycle, now))
+				}
+				break
+			}
+			b, ok := wd.waiting[gid]
+			if !ok {
+				break // gid runs
+			}
+			h, ok := wd.holders[b.m]
+			if !ok {
+				break // the lock was just released
+			}
+			pos[gid] = len(path)
+			path = append(path, gid)
+			gid = h.gid
+		}
+	}
+	return found
+}
+
+// deadlock describes the cycle of blocked goroutines.
+func (wd *Watchdog) deadlock(cycle []int64, now time.Time) *Deadlock {
+	d := &Deadlock{}
+	for i, gid := range cycle {
+		b := wd.waiting[gid]
+		// The goroutine holds the lock that the previous one waits for.
+		prev := wd.waiting[cycle[(i+len(cycle)-1)%len(cycle)]]
+		d.Cycle = append(d.Cycle, Wait{
+			Task:      b.w.task,
+			Goroutine: gid,
+			Holds:     prev.m.name,
+			WaitsFor:  b.m.name,
+			Since:     now.Sub(b.w.since),
+			Stack:     b.w.stack,
+		})
+	}
+	return d
+}
+
+// cycleKey identifies a cycle of goroutines independently of where
+// it starts.
+func cycleKey(cycle []int64) string {
+	min := 0
+	for i, gid := range cycle {
+		if gid < cycle[min] {
+			min = i
+		}
+	}
+	var b strings.Builder
+	for i := range cycle {
+		fmt.Fprintf(&b, "%d ", cycle[(min+i)%len(cycle)])
+	}
+	return b.String()
+}
+
+// stack returns the stack of the caller of Lock.
+func stack() string {
+	buf := make([]byte, 4096)
+	buf = buf[:runtime.Stack(buf, false)]
+	// Drop the first line ("goroutine 42 [running]:"),
+	// and the frames of stack and Lock, two lines each.
+	lines := strings.SplitN(string(buf), "\n", 6)
+	return lines[len(lines)-1]
+}
+
+// goid returns the ID of the current goroutine. Go does not expose it,
+// but the first line of a stack trace has it: "goroutine 42 [running]:".
+//
+// It is a deliberate copy of goid in the lockorder package, which keeps
+// it unexported: this lesson is a standalone module, and the helper is
+// not worth an exported API and a dependency.
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	b = b[:bytes.IndexByte(b, ' ')]
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		panic("cannot parse goroutine ID: " + err.Error())
+	}
+	return id
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A Lockable is a lock with a fixed position in the global lock order.
+//
+// Ordering files by their paths works for files, but not for locks
+// without a path, and a path can change while the file is locked.
+// A Lockable states its position explicitly: locks are ordered by rank,
+// and locks of the same rank by ID. Ranks express a hierarchy ("lock
+// the directory before its files"); IDs just need to be unique and must
+// never change.
+type Lockable interface {
+	// LockContext locks, or returns ctx.Err() if ctx ends first.
+	LockContext(ctx context.Context) error
+	Unlock()
+	Order() (rank int, id uint64)
+}
+
+// ErrSameOrder means that two different locks have the same rank and
+// ID, so LockAll cannot decide which one to lock first.
+var ErrSameOrder = errors.New("two locks have the same rank and ID")
+
+// LockAll locks all locks in the global order, no matter in which order
+// they are passed. If every goroutine acquires its locks with LockAll,
+// no goroutine can wait for a lock while holding a lock that comes later
+// in the order, hence there can be no cycle of goroutines that wait for
+// each other: no deadlock.
+//
+// A lock passed more than once is locked once. Two locks with the same
+// rank and ID that are not equal (==) make LockAll fail with
+// ErrSameOrder; so do two values of a type that is not comparable, as
+// LockAll cannot tell whether they are the same lock. If ctx ends before
+// all locks are acquired, LockAll unlocks the ones it has, and returns
+// the error. Otherwise, it returns a function that unlocks all locks in
+// reverse order. Calling the function more than once has no effect.
+func LockAll(ctx context.Context, locks ...Lockable) (unlock func(), err error) {
+	sorted := make([]Lockable, 0, len(locks))
+	sorted = append(sorted, locks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, ii := sorted[i].Order()
+		rj, ij := sorted[j].Order()
+		if ri != rj {
+			return ri < rj
+		}
+		return ii < ij
+	})
+
+	// Drop duplicates, which are next to each other now.
+	uniq := sorted[:0]
+	for i, l := range sorted {
+		if i > 0 {
+			prev := uniq[len(uniq)-1]
+			pr, pi := prev.Order()
+			if r, id := l.Order(); pr == r && pi == id {
+				if !same(prev, l) {
+					return nil, fmt.Errorf("LockAll: rank %d, ID %d: %w", r, id, ErrSameOrder)
+				}
+				continue
+			}
+		}
+		uniq = append(uniq, l)
+	}
+
+	release := func(held []Lockable) {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+	for i, l := range uniq {
+		if err := l.LockContext(ctx); err != nil {
+			release(uniq[:i])
+			return nil, fmt.Errorf("LockAll: lock %d of %d: %w", i+1, len(uniq), err)
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(func() { release(uniq) }) }, nil
+}
+
+// same reports whether a and b are equal. Unlike ==, it returns false
+// instead of panicking if their dynamic type is not comparable.
+func same(a, b Lockable) (eq bool) {
+	defer func() {
+		if recover() != nil {
+			eq = false
+		}
+	}()
+	return a == b
+}
+
+// lastID is the ID of the most recently created OrderedMutex.
+var lastID uint64
+
+// OrderedMutex is a mutex that implements Lockable. Unlike sync.Mutex,
+// a goroutine that waits for it can give up when a context ends.
+type OrderedMutex struct {
+	rank int
+	id   uint64
+	ch   chan struct{} // holds a value while locked
+}
+
+// NewOrderedMutex returns an unlocked mutex with the given rank,
+// and a unique ID that is higher than that of all mutexes created
+// before. Hence, within a rank, older mutexes come first.
+func NewOrderedMutex(rank int) *OrderedMutex {
+	return &OrderedMutex{
+		rank: rank,
+		id:   atomic.AddUint64(&lastID, 1),
+		ch:   make(chan struct{}, 1),
+	}
+}
+
+func (m *OrderedMutex) Order() (rank int, id uint64) {
+	return m.rank, m.id
+}
+
+func (m *OrderedMutex) Lock() {
+	m.ch <- struct{}{}
+}
+
+func (m *OrderedMutex) LockContext(ctx context.Context) error {
+	// Lock a free mutex even if ctx has ended already. With both
+	// cases ready, the select below would pick one at random.
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *OrderedMutex) Unlock() {
+	select {
+	case <-m.ch:
+	default:
+		panic("unlock of unlocked OrderedMutex")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// isFree reports whether m is unlocked, without waiting.
+func isFree(m *OrderedMutex) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if m.LockContext(ctx) != nil {
+		return false
+	}
+	m.Unlock()
+	return true
+}
+
+func TestLockAllNoDeadlock(t *testing.T) {
+	locks := make([]Lockable, 5)
+	for i := range locks {
+		locks[i] = NewOrderedMutex(0)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Many goroutines lock random subsets in random order.
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 200; i++ {
+				perm := r.Perm(len(locks))[:1+r.Intn(len(locks))]
+				subset := make([]Lockable, len(perm))
+				for j, p := range perm {
+					subset[j] = locks[p]
+				}
+				unlock, err := LockAll(ctx, subset...)
+				if err != nil {
+					t.Errorf("LockAll: %v (deadlock?)", err)
+					return
+				}
+				unlock()
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
+
+func TestLockAllOrder(t *testing.T) {
+	// Rank comes first, then ID: c has the highest ID, but the lowest rank.
+	a, b, c := NewOrderedMutex(1), NewOrderedMutex(1), NewOrderedMutex(0)
+	var order []Lockable
+	rec := func(m *OrderedMutex) Lockable { return recorder{m, &order} }
+	unlock, err := LockAll(context.Background(), rec(b), rec(a), rec(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if len(order) != 3 || order[0].(recorder).OrderedMutex != c ||
+		order[1].(recorder).OrderedMutex != a || order[2].(recorder).OrderedMutex != b {
+		t.Errorf("wrong locking order")
+	}
+}
+
+// recorder records the order of LockContext calls.
+type recorder struct {
+	*OrderedMutex
+	order *[]Lockable
+}
+
+func (r recorder) LockContext(ctx context.Context) error {
+	*r.order = append(*r.order, r)
+	return r.OrderedMutex.LockContext(ctx)
+}
+
+func TestLockAllTimeout(t *testing.T) {
+	a, b, c := NewOrderedMutex(0), NewOrderedMutex(0), NewOrderedMutex(0)
+	b.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	unlock, err := LockAll(ctx, c, b, a)
+	if !errors.Is(err, context.DeadlineExceeded) || unlock != nil {
+		t.Fatalf("LockAll = %v, %v, want nil, %v", unlock != nil, err, context.DeadlineExceeded)
+	}
+	// a was locked before LockAll had to wait for b; it is free again.
+	if !isFree(a) || !isFree(c) {
+		t.Error("LockAll did not release the locks it held")
+	}
+	b.Unlock()
+}
+
+func TestLockAllDuplicates(t *testing.T) {
+	a, b := NewOrderedMutex(0), NewOrderedMutex(0)
+	unlock, err := LockAll(context.Background(), a, b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isFree(a) || isFree(b) {
+		t.Error("locks are free while held")
+	}
+	unlock()
+	unlock() // no effect; would panic on an unlocked mutex
+	if !isFree(a) || !isFree(b) {
+		t.Error("locks are held after unlock")
+	}
+}
+
+// tagged is a Lockable whose dynamic type is not comparable.
+type tagged struct {
+	*OrderedMutex
+	tags []string
+}
+
+func TestLockAllNotComparable(t *testing.T) {
+	a := NewOrderedMutex(0)
+	// == would panic on these. LockAll cannot tell whether they
+	// are the same lock, and must not lock only one of them.
+	_, err := LockAll(context.Background(), tagged{a, nil}, tagged{a, []string{"again"}})
+	if !errors.Is(err, ErrSameOrder) {
+		t.Errorf("err = %v, want %v", err, ErrSameOrder)
+	}
+	if !isFree(a) {
+		t.Error("a is held after a failed LockAll")
+	}
+}
+
+func TestLockAllSameOrder(t *testing.T) {
+	a := NewOrderedMutex(0)
+	twin := &OrderedMutex{rank: a.rank, id: a.id, ch: make(chan struct{}, 1)}
+	if _, err := LockAll(context.Background(), a, twin); !errors.Is(err, ErrSameOrder) {
+		t.Errorf("err = %v, want %v", err, ErrSameOrder)
+	}
+	if !isFree(a) {
+		t.Error("a is held after a failed LockAll")
+	}
+}
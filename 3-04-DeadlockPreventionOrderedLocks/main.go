@@ -1,8 +1,9 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"strings"
 	"sync"
 	"time"
 )
@@ -11,35 +12,62 @@ import (
 type File struct {
 	path string
 	data [10]byte
-	mu   sync.Mutex
+	mu   *OrderedMutex
+}
+
+func newFile(path string) *File {
+	// All files have the same rank. Their IDs order them.
+	return &File{path: path, mu: NewOrderedMutex(0)}
 }
 
 func copyFileOrderedLock(task string, source, target *File) {
-	// determine the order of locking by ordering the file paths lexically
-	first := source
-	second := target
-	if strings.Compare(source.path, target.path) > 0 {
-		first = target
-		second = source
+	// Lock both files in the global order of their mutexes, the order
+	// that mergeFiles uses, too. Ordering by path would be a second
+	// order: "backup" sorts before "original", but its mutex comes
+	// after, and mixing two orders can deadlock again.
+	log.Printf("%s: lock %s and %s\n", task, source.path, target.path)
+	unlock, err := LockAll(context.Background(), source.mu, target.mu)
+	if err != nil {
+		// Not with a context that never ends.
+		log.Fatalf("%s: %s\n", task, err)
 	}
-
-	log.Printf("%s: lock first %s\n", task, first.path)
-	first.mu.Lock()
 	time.Sleep(time.Millisecond)
-	log.Printf("%s: lock second %s\n", task, second.path)
-	second.mu.Lock()
 
 	copy(target.data[:], source.data[:])
 
-	log.Printf("%s: unlock second %s\n", task, second.path)
-	second.mu.Unlock()
-	log.Printf("%s: unlock first %s\n", task, first.path)
-	first.mu.Unlock()
+	log.Printf("%s: unlock %s and %s\n", task, source.path, target.path)
+	unlock()
+}
+
+// mergeFiles combines the data of the sources into target. LockAll locks
+// any number of files in the same global order as copyFileOrderedLock,
+// so the two functions can run concurrently without a deadlock, and the
+// caller need not care about the locking order.
+func mergeFiles(ctx context.Context, task string, target *File, sources ...*File) error {
+	locks := []Lockable{target.mu}
+	for _, s := range sources {
+		locks = append(locks, s.mu)
+	}
+	log.Printf("%s: lock %d files\n", task, len(locks))
+	unlock, err := LockAll(ctx, locks...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	time.Sleep(time.Millisecond)
+
+	for _, s := range sources {
+		for i := range target.data {
+			target.data[i] ^= s.data[i]
+		}
+	}
+	log.Printf("%s: unlock %d files\n", task, len(locks))
+	return nil
 }
 
 func main() {
-	orig := &File{path: "original"}
-	bck := &File{path: "backup"}
+	orig := newFile("original")
+	bck := newFile("backup")
 	done := make(chan struct{})
 
 	go func() {
@@ -49,6 +77,22 @@ func main() {
 	copyFileOrderedLock("restore", bck, orig)
 
 	<-done
+
+	log.Println("\n*** LockAll ***")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a, b, c := newFile("a"), newFile("b"), newFile("c")
+	var wg sync.WaitGroup
+	for i, files := range [][]*File{{a, b, c}, {c, b, a}, {b, a}, {c, a, b}} {
+		wg.Add(1)
+		go func(task string, files []*File) {
+			defer wg.Done()
+			if err := mergeFiles(ctx, task, files[0], files[1:]...); err != nil {
+				log.Printf("%s: %s\n", task, err)
+			}
+		}(fmt.Sprintf("merge%d", i), files)
+	}
+	wg.Wait()
 }
 
 func init() {
@@ -0,0 +1,106 @@
+package chans
+
+import (
+	"context"
+	"time"
+)
+
+// Batch collects the items from in into slices of up to size items.
+// A batch is passed on when it is full, or maxWait after its first item
+// arrived, whichever comes first. If maxWait is zero, only full batches
+// and the last, partial batch are passed on. When in is closed, the
+// partial batch is passed on before the output channel is closed.
+// A size less than 1 is taken as 1: each item is a batch of its own.
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	if size < 1 {
+		size = 1
+	}
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var batch []T
+		var timer *time.Timer
+		var timeout <-chan time.Time // nil while the batch is empty
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timeout = nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			b := batch
+			batch = nil
+			return send(ctx, out, b)
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					timeout = timer.C
+				}
+				if len(batch) == size && !flush() {
+					return
+				}
+			case <-timeout:
+				timeout = nil
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Window collects the items from in into consecutive time windows of
+// length d, and passes on the items of each window when it ends.
+// Windows without items are skipped. When in is closed, the items of the
+// current window are passed on before the output channel is closed.
+func Window[T any](ctx context.Context, in <-chan T, d time.Duration) <-chan []T {
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		var window []T
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if len(window) > 0 {
+						send(ctx, out, window)
+					}
+					return
+				}
+				window = append(window, v)
+			case <-ticker.C:
+				if len(window) == 0 {
+					continue
+				}
+				// Items that arrive while the consumer is busy
+				// with this window are part of the next one.
+				if !send(ctx, out, window) {
+					return
+				}
+				window = nil
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,212 @@
+// Package chans implements generic, context-aware channel combinators.
+//
+// Every combinator starts one or more goroutines and returns the channels
+// it writes to. The goroutines end, and close their output channels,
+// when the input is exhausted or when ctx is canceled, whichever
+// comes first. Hence a consumer that stops reading early does not
+// leak goroutines, as long as it cancels ctx.
+//
+// After ctx is canceled, items that are in flight are discarded.
+package chans
+
+import "context"
+
+// send sends v to out, unless ctx is canceled first.
+// It reports whether v was sent.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recv receives from in, unless ctx is canceled first.
+// ok is false if in is closed or ctx is canceled.
+func recv[T any](ctx context.Context, in <-chan T) (v T, ok bool) {
+	select {
+	case v, ok = <-in:
+		return v, ok
+	case <-ctx.Done():
+		return v, false
+	}
+}
+
+// OrDone passes on the items from in until in is closed or ctx is canceled.
+// Use it to range over a channel that is not context-aware:
+//
+//	for v := range OrDone(ctx, in) { ... }
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Merge passes on the items from all input channels, in no particular
+// order, until all inputs are closed or ctx is canceled.
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	done := make(chan struct{})
+	for _, in := range ins {
+		in := in
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+	// Close out when all input goroutines are done.
+	go func() {
+		for range ins {
+			<-done
+		}
+		close(out)
+	}()
+	return out
+}
+
+// Broadcast passes on every item from in to each of n output channels.
+// A slow consumer holds up the others, as each item is delivered to
+// all outputs before the next item is read.
+func Broadcast[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]chan T, n)
+	ro := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		ro[i] = outs[i]
+	}
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return
+			}
+			for _, out := range outs {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}
+	}()
+	return ro
+}
+
+// Tee passes on every item from in to both output channels.
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	outs := Broadcast(ctx, in, 2)
+	return outs[0], outs[1]
+}
+
+// Map passes on fn(v) for every item v from in.
+func Map[T, U any](ctx context.Context, in <-chan T, fn func(T) U) <-chan U {
+	out := make(chan U)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok || !send(ctx, out, fn(v)) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Filter passes on the items from in for which keep returns true.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return
+			}
+			if keep(v) && !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Take passes on the first n items from in, then closes the output
+// channel. Take stops reading from in after n items; cancel ctx to
+// stop the goroutines that write to in.
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			v, ok := recv(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Buffer passes on the items from in through a channel with
+// room for size items, so that the writer to in can get ahead
+// of the consumer by up to size items.
+func Buffer[T any](ctx context.Context, in <-chan T, size int) <-chan T {
+	out := make(chan T, size)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Bridge flattens a channel of channels: it passes on all items from
+// the first channel it receives from chans until that channel is closed,
+// then all items from the next one, and so on.
+func Bridge[T any](ctx context.Context, chans <-chan (<-chan T)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			in, ok := recv(ctx, chans)
+			if !ok {
+				return
+			}
+			for {
+				v, ok := recv(ctx, in)
+				if !ok {
+					break
+				}
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
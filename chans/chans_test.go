@@ -0,0 +1,278 @@
+package chans
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkLeaks fails the test if the number of goroutines does not drop
+// back to the number at the time checkLeaks was called.
+// Use it as: defer checkLeaks(t)()
+func checkLeaks(t *testing.T) func() {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// count returns a channel with the numbers 0 to n-1.
+// If n is negative, count never ends.
+func count(ctx context.Context, n int) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; n < 0 || i < n; i++ {
+			if !send(ctx, out, i) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func collect[T any](ch <-chan T) []T {
+	var got []T
+	for v := range ch {
+		got = append(got, v)
+	}
+	return got
+}
+
+// abandon reads one item from each channel, then cancels
+// the context and does not read any further.
+func abandon[T any](t *testing.T, cancel context.CancelFunc, chs ...<-chan T) {
+	t.Helper()
+	for _, ch := range chs {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("no item received")
+		}
+	}
+	cancel()
+}
+
+func TestOrDone(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := collect(OrDone(ctx, count(ctx, 3))); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("got %v", got)
+	}
+
+	// The input never ends, and the consumer walks away.
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, OrDone(ctx, count(ctx, -1)))
+
+	// The input never sends anything.
+	ctx, cancel = context.WithCancel(context.Background())
+	out := OrDone(ctx, make(chan int))
+	cancel()
+	<-out
+}
+
+func TestMerge(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := collect(Merge(ctx, count(ctx, 3), count(ctx, 4))); len(got) != 7 {
+		t.Errorf("got %v, want 7 items", got)
+	}
+	if got := collect(Merge[int](ctx)); len(got) != 0 {
+		t.Errorf("got %v from no inputs", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, Merge(ctx, count(ctx, -1), count(ctx, -1), make(chan int)))
+}
+
+func TestBroadcast(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := Tee(ctx, count(ctx, 3))
+	done := make(chan []int)
+	go func() { done <- collect(a) }()
+	want := []int{0, 1, 2}
+	if got := collect(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("b: got %v", got)
+	}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Errorf("a: got %v", got)
+	}
+
+	// Only one of the consumers reads.
+	ctx, cancel = context.WithCancel(context.Background())
+	outs := Broadcast(ctx, count(ctx, -1), 3)
+	abandon(t, cancel, outs[0])
+}
+
+func TestMapFilter(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	even := Filter(ctx, count(ctx, 6), func(i int) bool { return i%2 == 0 })
+	squares := Map(ctx, even, func(i int) int { return i * i })
+	if got := collect(squares); !reflect.DeepEqual(got, []int{0, 4, 16}) {
+		t.Errorf("got %v", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel,
+		Map(ctx, count(ctx, -1), func(i int) int { return i }),
+		Filter(ctx, count(ctx, -1), func(i int) bool { return true }))
+
+	// A filter that drops everything must still notice cancellation.
+	ctx, cancel = context.WithCancel(context.Background())
+	out := Filter(ctx, count(ctx, -1), func(i int) bool { return false })
+	cancel()
+	<-out
+}
+
+func TestTake(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := collect(Take(ctx, count(ctx, -1), 3)); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("got %v", got)
+	}
+	if got := collect(Take(ctx, count(ctx, 2), 3)); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("got %v from a short input", got)
+	}
+	// The infinite count above ends with cancel.
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, Take(ctx, count(ctx, -1), 10))
+}
+
+func TestBuffer(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := Buffer(ctx, in, 5)
+	// The writer gets ahead of the reader by up to 5 items,
+	// plus one that Buffer holds while it waits for room.
+	for i := 0; i < 6; i++ {
+		select {
+		case in <- i:
+		case <-time.After(time.Second):
+			t.Fatalf("blocked after %d items", i)
+		}
+	}
+	close(in)
+	if got := collect(out); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("got %v", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, Buffer(ctx, count(ctx, -1), 5))
+}
+
+func TestBridge(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streams := make(chan (<-chan int), 3)
+	for i := 0; i < 3; i++ {
+		streams <- count(ctx, 2)
+	}
+	close(streams)
+	if got := collect(Bridge(ctx, streams)); !reflect.DeepEqual(got, []int{0, 1, 0, 1, 0, 1}) {
+		t.Errorf("got %v", got)
+	}
+
+	// An endless stream of endless streams.
+	ctx, cancel = context.WithCancel(context.Background())
+	endless := make(chan (<-chan int))
+	go func() {
+		defer close(endless)
+		for send(ctx, endless, count(ctx, -1)) {
+		}
+	}()
+	abandon(t, cancel, Bridge(ctx, endless))
+}
+
+func TestBatch(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := collect(Batch(ctx, count(ctx, 7), 3, 0))
+	if want := [][]int{{0, 1, 2}, {3, 4, 5}, {6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without a size limit, the batch would grow until in is closed.
+	got = collect(Batch(ctx, count(ctx, 3), 0, 0))
+	if want := [][]int{{0}, {1}, {2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("size 0: got %v, want %v", got, want)
+	}
+
+	// A partial batch is passed on after maxWait.
+	in := make(chan int)
+	out := Batch(ctx, in, 10, 10*time.Millisecond)
+	in <- 1
+	in <- 2
+	select {
+	case b := <-out:
+		if !reflect.DeepEqual(b, []int{1, 2}) {
+			t.Errorf("got %v", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch not passed on")
+	}
+	close(in)
+	if rest := collect(out); len(rest) != 0 {
+		t.Errorf("got %v after the timeout", rest)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, Batch(ctx, count(ctx, -1), 2, time.Millisecond))
+}
+
+func TestWindow(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// All items arrive within the first window.
+	if got := collect(Window(ctx, count(ctx, 5), time.Hour)); !reflect.DeepEqual(got, [][]int{{0, 1, 2, 3, 4}}) {
+		t.Errorf("got %v", got)
+	}
+
+	// Items that arrive slowly end up in several windows,
+	// and no item is lost.
+	in := make(chan int)
+	out := Window(ctx, in, 5*time.Millisecond)
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- i
+			time.Sleep(2 * time.Millisecond)
+		}
+		close(in)
+	}()
+	var all []int
+	windows := 0
+	for w := range out {
+		windows++
+		all = append(all, w...)
+	}
+	if windows < 2 || !reflect.DeepEqual(all, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("got %v in %d windows", all, windows)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	abandon(t, cancel, Window(ctx, count(ctx, -1), time.Millisecond))
+}
@@ -0,0 +1,3 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/chans
+
+go 1.19
@@ -0,0 +1,88 @@
+package chans
+
+import (
+	"context"
+	"sync"
+)
+
+// OrderedMap passes on fn(v) for every item v from in, in the order of
+// the input, while up to workers calls of fn run concurrently.
+//
+// Results that are ready before the results of earlier items wait in
+// a reorder buffer. When window items are waiting, or are still being
+// worked on, OrderedMap stops reading from in until the oldest result
+// is passed on. A single slow item thus holds up the output, but never
+// makes the buffer grow without bounds: OrderedMap holds at most
+// window+1 items, the window items in the buffer plus the oldest one,
+// which it waits for or hands to the consumer. Counted from the
+// consumer's side, the item it has just received is in flight, too,
+// so fn may run up to window+2 items ahead of what the consumer has
+// processed. window should be at least workers, or some workers will
+// sit idle.
+func OrderedMap[T, U any](ctx context.Context, in <-chan T, workers, window int, fn func(T) U) <-chan U {
+	if workers < 1 {
+		workers = 1
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	// Each item gets its own result channel, a promise of a result.
+	// The promises queue up in input order, and the worker that
+	// processes the item fulfills the promise.
+	type job struct {
+		v   T
+		res chan U
+	}
+	jobs := make(chan job)
+	promises := make(chan chan U, window)
+	out := make(chan U)
+
+	// The dispatcher queues up a promise for every item, then hands the
+	// item to a worker. The promise queue is the reorder buffer: when
+	// it is full, the dispatcher waits.
+	go func() {
+		defer close(jobs)
+		defer close(promises)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return
+			}
+			res := make(chan U, 1) // workers never wait for the emitter
+			if !send(ctx, promises, res) || !send(ctx, jobs, job{v, res}) {
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.res <- fn(j.v)
+			}
+		}()
+	}
+
+	// The emitter waits for the promises in input order.
+	go func() {
+		defer close(out)
+		// Wait for the workers, so that no call of fn is still
+		// running after out is closed.
+		defer wg.Wait()
+		for {
+			res, ok := recv(ctx, promises)
+			if !ok {
+				return
+			}
+			u, ok := recv(ctx, res)
+			if !ok || !send(ctx, out, u) {
+				return
+			}
+		}
+	}()
+	return out
+}
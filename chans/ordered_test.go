@@ -0,0 +1,85 @@
+package chans
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedMap(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const n, workers, window = 200, 8, 16
+	var started, emitted atomic.Int64
+	var tooMany atomic.Bool
+	slowSquare := func(i int) int {
+		// Items are started no further ahead of the output than the
+		// reorder buffer, plus the one item that the emitter holds,
+		// plus the one that the loop below has received but not
+		// counted yet.
+		if started.Add(1)-emitted.Load() > window+2 {
+			tooMany.Store(true)
+		}
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		return i * i
+	}
+
+	i := 0
+	for got := range OrderedMap(ctx, count(ctx, n), workers, window, slowSquare) {
+		emitted.Add(1)
+		if got != i*i {
+			t.Fatalf("result %d is %d, want %d", i, got, i*i)
+		}
+		i++
+	}
+	if i != n {
+		t.Errorf("got %d results, want %d", i, n)
+	}
+	if tooMany.Load() {
+		t.Errorf("more than %d items in flight", window+2)
+	}
+}
+
+func TestOrderedMapRunsConcurrently(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	sleep := func(i int) int {
+		time.Sleep(20 * time.Millisecond)
+		return i
+	}
+	if got := collect(OrderedMap(ctx, count(ctx, 10), 10, 10, sleep)); len(got) != 10 {
+		t.Fatalf("got %v", got)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("10 items with 10 workers took %s, want about 20ms", d)
+	}
+}
+
+func TestOrderedMapCancel(t *testing.T) {
+	defer checkLeaks(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	abandon(t, cancel, OrderedMap(ctx, count(ctx, -1), 4, 8, func(i int) int { return i }))
+
+	// A slow first item blocks the output; cancel must still get
+	// everything unstuck.
+	ctx, cancel = context.WithCancel(context.Background())
+	block := make(chan struct{})
+	out := OrderedMap(ctx, count(ctx, -1), 4, 8, func(i int) int {
+		if i == 0 {
+			<-block
+		}
+		return i
+	})
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	close(block)
+	for range out {
+	}
+}
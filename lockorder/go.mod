@@ -0,0 +1,3 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/lockorder
+
+go 1.19
@@ -0,0 +1,287 @@
+// Package lockorder provides a mutex that detects potential deadlocks
+// by tracking the order in which goroutines acquire locks.
+//
+// The copyFile deadlock of 3-02 happens because one goroutine locks
+// "original" and then "backup", while another one locks "backup" and
+// then "original". Whether the program hangs depends on the timing; the
+// bug is there either way. A Mutex records an edge A -> B whenever a
+// goroutine locks B while it holds A. If a new edge closes a cycle, some
+// schedule of the goroutines deadlocks, and Mutex reports the cycle,
+// with the stacks of the acquisitions on each edge, before it even
+// tries to lock. Each cycle is reported once.
+//
+// Recording costs a stack trace per Lock, and the graph of edges grows
+// with every pair of mutexes ever held together. Use Mutex in tests and
+// while debugging, not in production code.
+package lockorder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Mutex is a sync.Mutex that records the order of lock acquisitions.
+// It is a drop-in replacement: the zero value is an unlocked mutex.
+type Mutex struct {
+	mu sync.Mutex
+	// Name identifies the mutex in reports.
+	// If empty, reports use the mutex's address.
+	Name string
+}
+
+// An Edge is a lock acquisition while holding another lock.
+type Edge struct {
+	From, To  string // names of the held and the acquired mutex
+	Stack     string // stack trace of the acquisition of To
+	HeldStack string // stack trace of the acquisition of From
+}
+
+// An Inversion is a cycle of edges: each edge's To is the next edge's
+// From, and the last edge's To is the first edge's From. The last edge
+// is the one that closed the cycle.
+type Inversion struct {
+	Cycle []Edge
+}
+
+func (inv *Inversion) String() string {
+	var b strings.Builder
+	names := make([]string, 0, len(inv.Cycle)+1)
+	for _, e := range inv.Cycle {
+		names = append(names, e.From)
+	}
+	names = append(names, inv.Cycle[0].From)
+	fmt.Fprintf(&b, "lockorder: potential deadlock: lock order %s\n", strings.Join(names, " -> "))
+	for _, e := range inv.Cycle {
+		fmt.Fprintf(&b, "\n%s locked while holding %s at:\n%s", e.To, e.From, e.Stack)
+		fmt.Fprintf(&b, "%s was locked at:\n%s", e.From, e.HeldStack)
+	}
+	return b.String()
+}
+
+// Report is called for each new inversion. By default, it prints the
+// inversion to standard error. Set it before any Mutex is used, for
+// example to fail a test or to panic.
+var Report = func(inv *Inversion) {
+	fmt.Fprintln(os.Stderr, inv)
+}
+
+// held is a lock that a goroutine holds.
+type held struct {
+	m     *Mutex
+	stack []uintptr
+}
+
+type edge struct {
+	from, to *Mutex
+}
+
+// stacks are the stacks of the acquisitions of an edge's mutexes,
+// from the first time the edge was seen.
+type stacks struct {
+	from, to []uintptr
+}
+
+// The global lock graph. The graph's mutex is never held while waiting
+// for a Mutex, so it cannot take part in a deadlock itself.
+var graph = struct {
+	mu       sync.Mutex
+	held     map[int64][]held // locks held, by goroutine ID
+	edges    map[edge]stacks  // by edge, from its first acquisition
+	next     map[*Mutex][]*Mutex
+	reported map[string]bool // cycles reported, by their sorted names
+}{
+	held:     map[int64][]held{},
+	edges:    map[edge]stacks{},
+	next:     map[*Mutex][]*Mutex{},
+	reported: map[string]bool{},
+}
+
+// Lock records the acquisition, reports a new inversion,
+// and then locks m.
+func (m *Mutex) Lock() {
+	gid := goid()
+	stack := callers()
+
+	graph.mu.Lock()
+	// Each held lock adds an edge, and each edge may close a cycle.
+	var invs []*Inversion
+	for _, h := range graph.held[gid] {
+		if inv := addEdge(h, m, stack); inv != nil {
+			invs = append(invs, inv)
+		}
+	}
+	graph.mu.Unlock()
+	for _, inv := range invs {
+		Report(inv)
+	}
+
+	m.mu.Lock()
+	graph.mu.Lock()
+	graph.held[gid] = append(graph.held[gid], held{m, stack})
+	graph.mu.Unlock()
+}
+
+// TryLock tries to lock m and reports whether it succeeded. A TryLock
+// does not wait, hence it cannot deadlock, and it adds no edges.
+func (m *Mutex) TryLock() bool {
+	if !m.mu.TryLock() {
+		return false
+	}
+	gid := goid()
+	graph.mu.Lock()
+	graph.held[gid] = append(graph.held[gid], held{m, callers()})
+	graph.mu.Unlock()
+	return true
+}
+
+// Unlock unlocks m. As with sync.Mutex, a goroutine may unlock
+// a mutex that another goroutine locked.
+func (m *Mutex) Unlock() {
+	graph.mu.Lock()
+	if !release(goid(), m) {
+		for gid := range graph.held {
+			if release(gid, m) {
+				break
+			}
+		}
+	}
+	graph.mu.Unlock()
+	m.mu.Unlock()
+}
+
+// release removes m from the locks held by goroutine gid,
+// and reports whether gid held it.
+func release(gid int64, m *Mutex) bool {
+	hs := graph.held[gid]
+	for i := len(hs) - 1; i >= 0; i-- {
+		if hs[i].m == m {
+			hs = append(hs[:i], hs[i+1:]...)
+			if len(hs) == 0 {
+				delete(graph.held, gid)
+			} else {
+				graph.held[gid] = hs
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// addEdge records that to is being locked while h is held. If the
+// edge is new and closes a cycle that was not reported yet, addEdge
+// returns the cycle.
+func addEdge(h held, to *Mutex, stack []uintptr) *Inversion {
+	from := h.m
+	e := edge{from, to}
+	if _, ok := graph.edges[e]; ok {
+		return nil
+	}
+	graph.edges[e] = stacks{from: h.stack, to: stack}
+	graph.next[from] = append(graph.next[from], to)
+
+	path := findPath(to, from, map[*Mutex]bool{})
+	if path == nil {
+		return nil
+	}
+	// path leads from to to from; the new edge closes the cycle.
+	cycle := append(path, from)
+	key := cycleKey(cycle)
+	if graph.reported[key] {
+		return nil
+	}
+	graph.reported[key] = true
+
+	inv := &Inversion{}
+	for i, m := range cycle {
+		n := cycle[(i+1)%len(cycle)]
+		st := graph.edges[edge{m, n}]
+		inv.Cycle = append(inv.Cycle, Edge{
+			From:      m.name(),
+			To:        n.name(),
+			Stack:     format(st.to),
+			HeldStack: format(st.from),
+		})
+	}
+	return inv
+}
+
+// findPath returns the mutexes on a path of edges from a to b, excluding
+// b, or nil if there is none. A mutex that a goroutine locks twice is a
+// path from the mutex to itself.
+func findPath(a, b *Mutex, seen map[*Mutex]bool) []*Mutex {
+	if a == b {
+		return []*Mutex{}
+	}
+	seen[a] = true
+	for _, n := range graph.next[a] {
+		if seen[n] {
+			continue
+		}
+		if p := findPath(n, b, seen); p != nil {
+			return append([]*Mutex{a}, p...)
+		}
+	}
+	return nil
+}
+
+// cycleKey identifies a cycle independently of where it starts.
+func cycleKey(cycle []*Mutex) string {
+	min := 0
+	for i, m := range cycle {
+		if fmt.Sprintf("%p", m) < fmt.Sprintf("%p", cycle[min]) {
+			min = i
+		}
+	}
+	var b strings.Builder
+	for i := range cycle {
+		fmt.Fprintf(&b, "%p ", cycle[(min+i)%len(cycle)])
+	}
+	return b.String()
+}
+
+func (m *Mutex) name() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return fmt.Sprintf("%p", m)
+}
+
+// callers returns the stack of the caller of Lock or TryLock.
+func callers() []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+// format formats a stack like a panic does.
+func format(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// goid returns the ID of the current goroutine. Go hides goroutine IDs
+// on purpose, to discourage goroutine-local state, which is what this
+// package needs. The first line of a stack trace has the ID:
+// "goroutine 42 [running]:".
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	b = b[:bytes.IndexByte(b, ' ')]
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		panic("lockorder: cannot parse goroutine ID: " + err.Error())
+	}
+	return id
+}
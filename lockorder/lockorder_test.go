@@ -0,0 +1,200 @@
+package lockorder
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// record replaces Report with a function that collects the inversions,
+// for the duration of the test.
+func record(t *testing.T) *[]*Inversion {
+	var mu sync.Mutex
+	var got []*Inversion
+	old := Report
+	Report = func(inv *Inversion) {
+		mu.Lock()
+		got = append(got, inv)
+		mu.Unlock()
+	}
+	t.Cleanup(func() { Report = old })
+	return &got
+}
+
+func lockBoth(first, second *Mutex) {
+	first.Lock()
+	second.Lock()
+	second.Unlock()
+	first.Unlock()
+}
+
+func TestInversionWithoutDeadlock(t *testing.T) {
+	got := record(t)
+	orig, bck := &Mutex{Name: "original"}, &Mutex{Name: "backup"}
+
+	// The two orders never overlap in time, so there is no deadlock.
+	// The inversion is reported nonetheless.
+	done := make(chan struct{})
+	go func() {
+		lockBoth(orig, bck)
+		close(done)
+	}()
+	<-done
+	lockBoth(bck, orig)
+
+	if len(*got) != 1 {
+		t.Fatalf("%d inversions reported, want 1", len(*got))
+	}
+	inv := (*got)[0]
+	s := inv.String()
+	if !strings.Contains(s, "lock order original -> backup -> original") {
+		t.Errorf("report does not show the cycle:\n%s", s)
+	}
+	// Both edges are in the report, with the stacks of the acquisitions
+	// of both the held and the acquired mutex.
+	if len(inv.Cycle) != 2 || strings.Count(s, "lockorder.lockBoth") != 4 {
+		t.Errorf("report does not show all stacks:\n%s", s)
+	}
+	for _, name := range []string{"original", "backup"} {
+		if !strings.Contains(s, name+" was locked at:") {
+			t.Errorf("report does not show where %s was locked:\n%s", name, s)
+		}
+	}
+
+	// The same inversion is reported once.
+	lockBoth(bck, orig)
+	lockBoth(orig, bck)
+	if len(*got) != 1 {
+		t.Errorf("%d inversions reported, want 1", len(*got))
+	}
+}
+
+func TestConsistentOrder(t *testing.T) {
+	got := record(t)
+	a, b, c := &Mutex{Name: "a"}, &Mutex{Name: "b"}, &Mutex{Name: "c"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Lock()
+			b.Lock()
+			c.Lock()
+			c.Unlock()
+			b.Unlock()
+			a.Unlock()
+			lockBoth(a, c)
+		}()
+	}
+	wg.Wait()
+	if len(*got) != 0 {
+		t.Errorf("unexpected report:\n%s", (*got)[0])
+	}
+}
+
+func TestLongerCycle(t *testing.T) {
+	got := record(t)
+	a, b, c := &Mutex{Name: "a"}, &Mutex{Name: "b"}, &Mutex{Name: "c"}
+	lockBoth(a, b)
+	lockBoth(b, c)
+	if len(*got) != 0 {
+		t.Fatalf("unexpected report:\n%s", (*got)[0])
+	}
+	lockBoth(c, a)
+	if len(*got) != 1 || len((*got)[0].Cycle) != 3 {
+		t.Fatalf("want one report of a cycle of three, got %d reports", len(*got))
+	}
+	if s := (*got)[0].String(); !strings.Contains(s, "a -> b -> c -> a") {
+		t.Errorf("report does not show the cycle:\n%s", s)
+	}
+}
+
+func TestTwoInversionsInOneLock(t *testing.T) {
+	got := record(t)
+	a, b, c := &Mutex{Name: "a"}, &Mutex{Name: "b"}, &Mutex{Name: "c"}
+	lockBoth(c, a)
+	lockBoth(c, b)
+	// Locking c while holding a and b inverts both orders.
+	// TryLock adds no edge a -> b, which would make a longer cycle.
+	a.Lock()
+	if !b.TryLock() {
+		t.Fatal("TryLock failed")
+	}
+	c.Lock()
+	c.Unlock()
+	b.Unlock()
+	a.Unlock()
+	if len(*got) != 2 {
+		t.Fatalf("%d inversions reported, want 2", len(*got))
+	}
+	var cycles []string
+	for _, inv := range *got {
+		cycles = append(cycles, inv.String())
+	}
+	all := strings.Join(cycles, "\n")
+	for _, want := range []string{"c -> a -> c", "c -> b -> c"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("no report of %s:\n%s", want, all)
+		}
+	}
+}
+
+func lockA(a *Mutex) { a.Lock() }
+
+func TestHeldStack(t *testing.T) {
+	got := record(t)
+	a, b := &Mutex{Name: "a"}, &Mutex{Name: "b"}
+	lockBoth(b, a)
+	// a is locked in another function than b, while it is held.
+	lockA(a)
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+	if len(*got) != 1 {
+		t.Fatalf("%d inversions reported, want 1", len(*got))
+	}
+	for _, e := range (*got)[0].Cycle {
+		if e.From == "a" && !strings.Contains(e.HeldStack, "lockorder.lockA") {
+			t.Errorf("stack of the acquisition of a does not show lockA:\n%s", e.HeldStack)
+		}
+		if e.From == "a" && strings.Contains(e.Stack, "lockorder.lockA") {
+			t.Errorf("stack of the acquisition of b shows lockA:\n%s", e.Stack)
+		}
+	}
+}
+
+func TestUnlockByOtherGoroutine(t *testing.T) {
+	got := record(t)
+	a, b := &Mutex{Name: "a"}, &Mutex{Name: "b"}
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		a.Unlock()
+		close(done)
+	}()
+	<-done
+	// a is no longer held, so this is no edge a -> b.
+	b.Lock()
+	a.Lock()
+	a.Unlock()
+	b.Unlock()
+	lockBoth(a, b)
+	if len(*got) != 1 {
+		t.Errorf("%d inversions reported, want 1 (b -> a, then a -> b)", len(*got))
+	}
+}
+
+func TestTryLockAddsNoEdge(t *testing.T) {
+	got := record(t)
+	a, b := &Mutex{Name: "a"}, &Mutex{Name: "b"}
+	a.Lock()
+	if !b.TryLock() {
+		t.Fatal("TryLock failed")
+	}
+	b.Unlock()
+	a.Unlock()
+	lockBoth(b, a)
+	if len(*got) != 0 {
+		t.Errorf("unexpected report:\n%s", (*got)[0])
+	}
+}
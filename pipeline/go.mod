@@ -0,0 +1,5 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/pipeline
+
+go 1.19
+
+require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
@@ -0,0 +1,453 @@
+// Package pipeline builds multi-stage pipelines from a declaration of
+// their stages.
+//
+// Each stage runs a function in a number of worker goroutines and reads
+// from an input queue of a given size. Stages are connected by Streams:
+// every stage except a Sink returns the Stream of its output, which is
+// the input of the next stage.
+//
+//	p := pipeline.New()
+//	files := pipeline.Source(p, "walk", walk)
+//	parsed := pipeline.Map(p, "parse", files, pipeline.Options{Workers: 4, Buffer: 16}, parse)
+//	pipeline.Sink(p, "print", parsed, pipeline.Options{}, print)
+//	err := p.Run(ctx)
+//
+// Nothing runs until Run is called. Run starts all stages and waits for
+// them to finish. If any stage returns an error, the context of all stages
+// is canceled, and Run returns the first error, as with an errgroup.
+//
+// While the pipeline runs, and after it is done, Stats returns the
+// throughput, queue depth, and busy ratio of each stage. The busy ratio
+// is the time the workers spent in the stage function, except for the
+// time they waited for the next stage to accept their output. A stage
+// with a high busy ratio and a full input queue is a bottleneck; it may
+// need more workers.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Options configure a stage.
+type Options struct {
+	Workers int // number of worker goroutines; at least 1
+	Buffer  int // size of the input queue; at least 0
+}
+
+// Pipeline is a set of connected stages.
+type Pipeline struct {
+	mu      sync.Mutex
+	stages  []*stage
+	streams []stream
+	started bool
+}
+
+// New returns an empty pipeline.
+func New() *Pipeline {
+	return &Pipeline{}
+}
+
+// stream is the type-independent view of a Stream.
+type stream interface {
+	producer() string
+	attached() bool
+}
+
+// Stream is the output of a stage. Exactly one stage must consume it.
+type Stream[T any] struct {
+	from   string // name of the producing stage
+	q      *queue[T]
+	merged []*Stream[T] // the inputs of a Merge
+}
+
+// attach makes q the destination of s.
+func (s *Stream[T]) attach(q *queue[T]) {
+	if s.q != nil {
+		panic(fmt.Sprintf("pipeline: output of %s consumed by two stages; use Broadcast", s.from))
+	}
+	s.q = q
+	if s.merged == nil {
+		q.producers.Add(1)
+		return
+	}
+	for _, in := range s.merged {
+		in.attach(q)
+	}
+}
+
+func (s *Stream[T]) producer() string { return s.from }
+func (s *Stream[T]) attached() bool   { return s.q != nil }
+
+// queue is the input channel of a stage. Several streams can feed the
+// same queue, as with Merge; the last producer to finish closes it.
+type queue[T any] struct {
+	ch        chan T
+	producers atomic.Int32
+}
+
+// done is called by each producer when it has finished.
+func (q *queue[T]) done() {
+	if q.producers.Add(-1) == 0 {
+		close(q.ch)
+	}
+}
+
+// stage holds the description and the counters of a stage.
+type stage struct {
+	name    string
+	workers int
+	work    func(ctx context.Context, st *stage) error // the loop of one worker
+	finish  func()                                     // after all workers are done
+	depth   func() (n, cap int)                        // of the input queue; nil for sources
+
+	in, out   atomic.Int64
+	busy      atomic.Int64 // nanoseconds
+	maxQueue  atomic.Int64
+	startTime atomic.Int64 // Unix nanoseconds
+	endTime   atomic.Int64
+}
+
+// add registers a stage. It panics if the pipeline is running, or if a
+// stage with the same name exists: both are mistakes in the program, not
+// runtime conditions.
+func (p *Pipeline) add(st *stage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		panic("pipeline: stage " + st.name + " added to a running pipeline")
+	}
+	for _, s := range p.stages {
+		if s.name == st.name {
+			panic("pipeline: duplicate stage " + st.name)
+		}
+	}
+	if st.workers < 1 {
+		st.workers = 1
+	}
+	p.stages = append(p.stages, st)
+}
+
+// output creates the output stream of stage name.
+func output[T any](p *Pipeline, name string) *Stream[T] {
+	s := &Stream[T]{from: name}
+	p.mu.Lock()
+	p.streams = append(p.streams, s)
+	p.mu.Unlock()
+	return s
+}
+
+// consume attaches a new input queue for stage name to the streams,
+// and returns it.
+func consume[T any](name string, opts Options, ins ...*Stream[T]) *queue[T] {
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
+	}
+	q := &queue[T]{ch: make(chan T, opts.Buffer)}
+	for _, in := range ins {
+		in.attach(q)
+	}
+	if q.producers.Load() == 0 {
+		close(q.ch) // a Merge of nothing
+	}
+	return q
+}
+
+// recv returns the next item from q, and counts it.
+// ok is false if q is closed or ctx is canceled.
+func recv[T any](ctx context.Context, st *stage, q *queue[T]) (v T, ok bool, err error) {
+	select {
+	case v, ok = <-q.ch:
+		if ok {
+			st.in.Add(1)
+			// The item just taken was in the queue, too. A producer may
+			// have refilled its slot already, hence the cap.
+			n := int64(len(q.ch)) + 1
+			if c := int64(cap(q.ch)); n > c {
+				n = c
+			}
+			for m := st.maxQueue.Load(); n > m && !st.maxQueue.CompareAndSwap(m, n); m = st.maxQueue.Load() {
+			}
+		}
+		return v, ok, nil
+	case <-ctx.Done():
+		return v, false, ctx.Err()
+	}
+}
+
+// emitter returns a function that sends items to the output stream,
+// and a function that returns how long the sends were blocked.
+func emitter[T any](ctx context.Context, st *stage, out *Stream[T]) (emit func(T) error, blocked func() time.Duration) {
+	var b time.Duration
+	emit = func(v T) error {
+		select {
+		case out.q.ch <- v:
+			st.out.Add(1)
+			return nil
+		default:
+		}
+		start := time.Now()
+		defer func() { b += time.Since(start) }()
+		select {
+		case out.q.ch <- v:
+			st.out.Add(1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return emit, func() time.Duration { return b }
+}
+
+// timed calls fn and adds its run time, except for the time that
+// emit was blocked, to the busy time of st.
+func timed(st *stage, blocked func() time.Duration, fn func() error) error {
+	start := time.Now()
+	b := blocked()
+	err := fn()
+	st.busy.Add(int64(time.Since(start) - (blocked() - b)))
+	return err
+}
+
+// Source adds a stage that produces items by calling emit. fn runs in
+// a single goroutine and must return when ctx is canceled. emit returns
+// an error if ctx is canceled.
+func Source[T any](p *Pipeline, name string, fn func(ctx context.Context, emit func(T) error) error) *Stream[T] {
+	out := output[T](p, name)
+	st := &stage{name: name, workers: 1}
+	st.work = func(ctx context.Context, st *stage) error {
+		emit, blocked := emitter(ctx, st, out)
+		return timed(st, blocked, func() error { return fn(ctx, emit) })
+	}
+	st.finish = func() { out.q.done() }
+	p.add(st)
+	return out
+}
+
+// FlatMap adds a stage that calls fn for every item from in. fn can pass
+// any number of items on to the output by calling emit.
+func FlatMap[In, Out any](p *Pipeline, name string, in *Stream[In], opts Options, fn func(ctx context.Context, v In, emit func(Out) error) error) *Stream[Out] {
+	q := consume(name, opts, in)
+	out := output[Out](p, name)
+	st := &stage{name: name, workers: opts.Workers}
+	st.work = func(ctx context.Context, st *stage) error {
+		emit, blocked := emitter(ctx, st, out)
+		for {
+			v, ok, err := recv(ctx, st, q)
+			if !ok {
+				return err
+			}
+			if err := timed(st, blocked, func() error { return fn(ctx, v, emit) }); err != nil {
+				return err
+			}
+		}
+	}
+	st.finish = func() { out.q.done() }
+	st.depth = func() (int, int) { return len(q.ch), cap(q.ch) }
+	p.add(st)
+	return out
+}
+
+// Map adds a stage that passes on fn(v) for every item v from in.
+func Map[In, Out any](p *Pipeline, name string, in *Stream[In], opts Options, fn func(ctx context.Context, v In) (Out, error)) *Stream[Out] {
+	return FlatMap(p, name, in, opts, func(ctx context.Context, v In, emit func(Out) error) error {
+		u, err := fn(ctx, v)
+		if err != nil {
+			return err
+		}
+		return emit(u)
+	})
+}
+
+// Sink adds a final stage that calls fn for every item from in.
+func Sink[T any](p *Pipeline, name string, in *Stream[T], opts Options, fn func(ctx context.Context, v T) error) {
+	q := consume(name, opts, in)
+	st := &stage{name: name, workers: opts.Workers}
+	st.work = func(ctx context.Context, st *stage) error {
+		noBlock := func() time.Duration { return 0 }
+		for {
+			v, ok, err := recv(ctx, st, q)
+			if !ok {
+				return err
+			}
+			if err := timed(st, noBlock, func() error { return fn(ctx, v) }); err != nil {
+				return err
+			}
+		}
+	}
+	st.finish = func() {}
+	st.depth = func() (int, int) { return len(q.ch), cap(q.ch) }
+	p.add(st)
+}
+
+// Broadcast adds a stage that passes on every item from in to each of
+// n output streams. The slowest consumer sets the pace.
+func Broadcast[T any](p *Pipeline, name string, in *Stream[T], opts Options, n int) []*Stream[T] {
+	q := consume(name, opts, in)
+	outs := make([]*Stream[T], n)
+	for i := range outs {
+		outs[i] = output[T](p, fmt.Sprintf("%s[%d]", name, i))
+	}
+	// Items must reach all outputs in the same order,
+	// so a broadcast has a single worker.
+	st := &stage{name: name, workers: 1}
+	st.work = func(ctx context.Context, st *stage) error {
+		emits := make([]func(T) error, n)
+		blocks := make([]func() time.Duration, n)
+		for i, out := range outs {
+			emits[i], blocks[i] = emitter(ctx, st, out)
+		}
+		blocked := func() time.Duration {
+			var sum time.Duration
+			for _, b := range blocks {
+				sum += b()
+			}
+			return sum
+		}
+		for {
+			v, ok, err := recv(ctx, st, q)
+			if !ok {
+				return err
+			}
+			err = timed(st, blocked, func() error {
+				for _, emit := range emits {
+					if err := emit(v); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	st.finish = func() {
+		for _, out := range outs {
+			out.q.done()
+		}
+	}
+	st.depth = func() (int, int) { return len(q.ch), cap(q.ch) }
+	p.add(st)
+	return outs
+}
+
+// Merge returns a stream with the items of all input streams, in no
+// particular order. A merge is not a stage of its own: the producers
+// of the input streams share the input queue of the next stage.
+func Merge[T any](p *Pipeline, ins ...*Stream[T]) *Stream[T] {
+	name := ""
+	for i, in := range ins {
+		if i > 0 {
+			name += "+"
+		}
+		name += in.from
+	}
+	merged := output[T](p, name)
+	merged.merged = append([]*Stream[T]{}, ins...)
+	return merged
+}
+
+// Run starts all stages and waits until they are done. It returns the
+// first error of any stage, or nil if all input was processed. Run can
+// only be called once.
+func (p *Pipeline) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return fmt.Errorf("pipeline: Run called twice")
+	}
+	p.started = true
+	for _, s := range p.streams {
+		if !s.attached() {
+			p.mu.Unlock()
+			return fmt.Errorf("pipeline: output of %s is not consumed", s.producer())
+		}
+	}
+	stages := p.stages
+	p.mu.Unlock()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, st := range stages {
+		st := st
+		st.startTime.Store(time.Now().UnixNano())
+		var workers sync.WaitGroup
+		workers.Add(st.workers)
+		for i := 0; i < st.workers; i++ {
+			g.Go(func() error {
+				defer workers.Done()
+				return st.work(ctx, st)
+			})
+		}
+		g.Go(func() error {
+			workers.Wait()
+			st.endTime.Store(time.Now().UnixNano())
+			st.finish()
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// StageStats describe the work of a stage.
+type StageStats struct {
+	Name       string
+	Workers    int
+	In, Out    int64         // items received and emitted
+	Elapsed    time.Duration // since the stage started, until it ended
+	Throughput float64       // items processed per second: In, or Out for sources
+	Queue      int           // items in the input queue now
+	QueueCap   int           // size of the input queue
+	MaxQueue   int64         // most items seen in the input queue
+	BusyRatio  float64       // share of the workers' time spent working, from 0 to 1
+}
+
+func (s StageStats) String() string {
+	return fmt.Sprintf("%-12s %2d workers %7d in %7d out %9.1f/s  queue %3d/%-3d (max %3d)  busy %5.1f%%",
+		s.Name, s.Workers, s.In, s.Out, s.Throughput, s.Queue, s.QueueCap, s.MaxQueue, s.BusyRatio*100)
+}
+
+// Stats returns the statistics of all stages, in the order they were added.
+// Stats can be called at any time, including while the pipeline runs.
+func (p *Pipeline) Stats() []StageStats {
+	p.mu.Lock()
+	stages := p.stages
+	p.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	stats := make([]StageStats, 0, len(stages))
+	for _, st := range stages {
+		s := StageStats{
+			Name:     st.name,
+			Workers:  st.workers,
+			In:       st.in.Load(),
+			Out:      st.out.Load(),
+			MaxQueue: st.maxQueue.Load(),
+		}
+		if st.depth != nil {
+			s.Queue, s.QueueCap = st.depth()
+		}
+		if start := st.startTime.Load(); start != 0 {
+			end := st.endTime.Load()
+			if end == 0 {
+				end = now
+			}
+			s.Elapsed = time.Duration(end - start)
+		}
+		if s.Elapsed > 0 {
+			n := s.In
+			if st.depth == nil {
+				n = s.Out
+			}
+			s.Throughput = float64(n) / s.Elapsed.Seconds()
+			s.BusyRatio = float64(st.busy.Load()) / (float64(s.Elapsed) * float64(st.workers))
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
@@ -0,0 +1,192 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// numbers emits 1 to n, or counts forever if n is negative.
+func numbers(n int) func(ctx context.Context, emit func(int) error) error {
+	return func(ctx context.Context, emit func(int) error) error {
+		for i := 1; n < 0 || i <= n; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	p := New()
+	nums := Source(p, "numbers", numbers(100))
+	squares := Map(p, "square", nums, Options{Workers: 4, Buffer: 8}, func(ctx context.Context, i int) (int, error) {
+		return i * i, nil
+	})
+	both := Broadcast(p, "broadcast", squares, Options{}, 2)
+	neg := Map(p, "negate", both[1], Options{Workers: 2}, func(ctx context.Context, i int) (int, error) {
+		return -i, nil
+	})
+	pairs := FlatMap(p, "twice", both[0], Options{}, func(ctx context.Context, i int, emit func(int) error) error {
+		if err := emit(i); err != nil {
+			return err
+		}
+		return emit(i)
+	})
+	var sum, count int
+	Sink(p, "sum", Merge(p, pairs, neg), Options{Buffer: 4}, func(ctx context.Context, i int) error {
+		sum += i
+		count++
+		return nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Every square is added twice and subtracted once.
+	want := 0
+	for i := 1; i <= 100; i++ {
+		want += i * i
+	}
+	if sum != want || count != 300 {
+		t.Errorf("got sum %d of %d items, want %d of 300", sum, count, want)
+	}
+
+	stats := p.Stats()
+	if len(stats) != 6 {
+		t.Fatalf("got %d stages, want 6", len(stats))
+	}
+	for _, s := range stats {
+		t.Log(s)
+	}
+	if s := stats[1]; s.Name != "square" || s.In != 100 || s.Out != 100 || s.Workers != 4 || s.QueueCap != 8 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s := stats[5]; s.Name != "sum" || s.In != 300 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestNegativeOptions(t *testing.T) {
+	p := New()
+	doubled := Map(p, "double", Source(p, "numbers", numbers(10)), Options{Workers: -1, Buffer: -1}, func(ctx context.Context, i int) (int, error) {
+		return 2 * i, nil
+	})
+	sum := 0
+	Sink(p, "sum", doubled, Options{Buffer: -5}, func(ctx context.Context, i int) error {
+		sum += i
+		return nil
+	})
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 110 {
+		t.Errorf("sum = %d, want 110", sum)
+	}
+}
+
+func TestErrorCancelsPipeline(t *testing.T) {
+	before := runtime.NumGoroutine()
+	failed := errors.New("failed")
+
+	p := New()
+	nums := Source(p, "numbers", numbers(-1))
+	checked := Map(p, "check", nums, Options{Workers: 3, Buffer: 10}, func(ctx context.Context, i int) (int, error) {
+		if i == 50 {
+			return 0, failed
+		}
+		return i, nil
+	})
+	Sink(p, "discard", checked, Options{Workers: 2}, func(ctx context.Context, i int) error {
+		return nil
+	})
+
+	done := make(chan error)
+	go func() { done <- p.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, failed) {
+			t.Errorf("got error %v, want %v", err, failed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline did not stop")
+	}
+
+	// All goroutines are gone.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParentContextCancelsPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	p := New()
+	var n atomic.Int64
+	Sink(p, "count", Source(p, "numbers", numbers(-1)), Options{}, func(ctx context.Context, i int) error {
+		n.Add(1)
+		return nil
+	})
+	if err := p.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n.Load() == 0 {
+		t.Error("nothing processed")
+	}
+}
+
+func TestStatsShowBottleneck(t *testing.T) {
+	p := New()
+	nums := Source(p, "numbers", numbers(50))
+	slow := Map(p, "slow", nums, Options{Workers: 1, Buffer: 10}, func(ctx context.Context, i int) (int, error) {
+		time.Sleep(2 * time.Millisecond)
+		return i, nil
+	})
+	Sink(p, "fast", slow, Options{Workers: 1, Buffer: 10}, func(ctx context.Context, i int) error {
+		return nil
+	})
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Stats()
+	for _, s := range stats {
+		t.Log(s)
+	}
+	slowStats, fastStats := stats[1], stats[2]
+	if slowStats.BusyRatio < 0.8 || slowStats.MaxQueue < 9 {
+		t.Errorf("slow stage: busy ratio %.2f, max queue %d; want a busy stage with a full queue", slowStats.BusyRatio, slowStats.MaxQueue)
+	}
+	if fastStats.BusyRatio > 0.2 || fastStats.MaxQueue > 2 {
+		t.Errorf("fast stage: busy ratio %.2f, max queue %d; want an idle stage with an empty queue", fastStats.BusyRatio, fastStats.MaxQueue)
+	}
+	if slowStats.Throughput < 100 || slowStats.Throughput > 600 {
+		t.Errorf("slow stage: throughput %.0f/s, want about 500/s", slowStats.Throughput)
+	}
+}
+
+func TestDeclarationErrors(t *testing.T) {
+	p := New()
+	Source(p, "numbers", numbers(1))
+	if err := p.Run(context.Background()); err == nil {
+		t.Error("unconsumed stream: no error")
+	}
+
+	p = New()
+	nums := Source(p, "numbers", numbers(1))
+	Sink(p, "a", nums, Options{}, func(context.Context, int) error { return nil })
+	defer func() {
+		if recover() == nil {
+			t.Error("stream consumed twice: no panic")
+		}
+	}()
+	Sink(p, "b", nums, Options{}, func(context.Context, int) error { return nil })
+}
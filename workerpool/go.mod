@@ -0,0 +1,3 @@
+module github.com/AppliedGoCourses/ConcurrencyDeepDive/workerpool
+
+go 1.19
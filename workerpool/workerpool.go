@@ -0,0 +1,322 @@
+// Package workerpool implements a pool of worker goroutines that grows
+// and shrinks with the load.
+//
+// A fixed number of workers, like the numWorkers = 3 of the trivial
+// fan-out in 2-06, is either too many for a trickle of work or too few
+// for a burst. A Pool starts with Min workers. When a task is submitted,
+// no worker is idle, and the queue of waiting tasks has reached a
+// threshold, the pool starts another worker, up to Max. A worker that
+// has been idle for IdleTimeout retires, down to Min.
+//
+// Close stops the pool gracefully: it rejects new tasks, lets the workers
+// finish all queued tasks, and waits for them.
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Submit after Close.
+var ErrClosed = errors.New("workerpool: pool is closed")
+
+// Options configure a Pool.
+type Options struct {
+	Min int // workers that never retire; may be 0
+	Max int // upper limit of workers; at least 1
+
+	// QueueSize is the number of tasks that can wait for a worker.
+	// If the queue is full, Submit blocks.
+	QueueSize int
+
+	// ScaleUpAt is the number of waiting tasks, including the one being
+	// submitted, at which the pool starts another worker if none is idle.
+	// The default is 1: start a worker whenever no worker is idle.
+	ScaleUpAt int
+
+	// IdleTimeout is how long a worker waits for a task before it
+	// retires. The default is one second.
+	IdleTimeout time.Duration
+
+	// OnScale, if not nil, is called for every worker that is
+	// started or retired.
+	OnScale func(ScaleEvent)
+}
+
+// A ScaleEvent records a scaling decision.
+type ScaleEvent struct {
+	Time    time.Time
+	Up      bool   // a worker was started, rather than retired
+	Workers int    // number of workers after the change
+	Queue   int    // number of waiting tasks at the time
+	Reason  string // why the pool scaled
+}
+
+func (e ScaleEvent) String() string {
+	dir := "down"
+	if e.Up {
+		dir = "up"
+	}
+	return fmt.Sprintf("scale %s to %d workers, %d queued: %s", dir, e.Workers, e.Queue, e.Reason)
+}
+
+// Metrics describe the state and the history of a Pool.
+type Metrics struct {
+	Workers     int   // running workers
+	Idle        int   // workers waiting for a task
+	Queued      int   // tasks waiting for a worker
+	PeakWorkers int   // most workers at any time
+	ScaleUps    int64 // workers started beyond Min
+	ScaleDowns  int64 // workers retired
+	Submitted   int64 // tasks accepted by Submit
+	Completed   int64 // tasks that have run
+}
+
+// Pool is a pool of workers that run submitted tasks.
+type Pool struct {
+	opts  Options
+	tasks chan func()
+
+	// closeMu guards closing the tasks channel: Submit holds a read
+	// lock while it sends, Close takes the write lock to close.
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu         sync.Mutex // guards workers, retired, peak, and the scale counters
+	workers    int
+	retired    chan struct{} // closed and replaced when a worker retires
+	peak       int
+	scaleUps   int64
+	scaleDowns int64
+
+	idle      atomic.Int64
+	submitted atomic.Int64
+	completed atomic.Int64
+	wg        sync.WaitGroup
+	done      chan struct{} // closed when all workers have exited after Close
+}
+
+// New returns a pool and starts opts.Min workers.
+func New(opts Options) *Pool {
+	if opts.Max < 1 {
+		opts.Max = 1
+	}
+	if opts.Min > opts.Max {
+		opts.Min = opts.Max
+	}
+	if opts.Min < 0 {
+		opts.Min = 0
+	}
+	if opts.ScaleUpAt < 1 {
+		opts.ScaleUpAt = 1
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = time.Second
+	}
+	p := &Pool{
+		opts:    opts,
+		tasks:   make(chan func(), opts.QueueSize),
+		retired: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	p.mu.Lock()
+	for i := 0; i < opts.Min; i++ {
+		p.startLocked()
+	}
+	p.mu.Unlock()
+	return p
+}
+
+// Submit queues task to be run by a worker. It blocks while the queue
+// is full, and returns ctx.Err() if ctx is canceled before the task is
+// queued, or ErrClosed if the pool is closed.
+func (p *Pool) Submit(ctx context.Context, task func()) error {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	for {
+		retired := p.maybeGrow()
+		select {
+		case p.tasks <- task:
+			p.submitted.Add(1)
+			// The last worker may have retired before the task
+			// was queued.
+			p.mu.Lock()
+			var ev *ScaleEvent
+			if p.workers == 0 {
+				ev = p.startLocked()
+				ev.Queue = len(p.tasks)
+				ev.Reason = "last worker retired"
+			}
+			p.mu.Unlock()
+			if ev != nil {
+				p.notify(*ev)
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retired:
+			// The idle worker that maybeGrow counted on is gone.
+		}
+	}
+}
+
+// maybeGrow starts a worker if the queue backs up and no worker is idle.
+// It returns a channel that is closed when a worker retires.
+func (p *Pool) maybeGrow() (retired <-chan struct{}) {
+	p.mu.Lock()
+	retired = p.retired
+	var ev *ScaleEvent
+	queued := len(p.tasks) + 1 // including the task being submitted
+	switch {
+	case p.workers == 0:
+		ev = p.startLocked()
+		ev.Reason = "no workers"
+	case p.workers < p.opts.Max && p.idle.Load() == 0 && queued >= p.opts.ScaleUpAt:
+		ev = p.startLocked()
+		ev.Reason = fmt.Sprintf("no idle worker and %d queued", queued)
+	}
+	p.mu.Unlock()
+	if ev != nil {
+		ev.Queue = queued
+		p.notify(*ev)
+	}
+	return retired
+}
+
+// startLocked starts a worker. p.mu must be held.
+func (p *Pool) startLocked() *ScaleEvent {
+	p.workers++
+	if p.workers > p.peak {
+		p.peak = p.workers
+	}
+	if p.workers > p.opts.Min {
+		p.scaleUps++
+	}
+	p.wg.Add(1)
+	go p.work()
+	return &ScaleEvent{Time: time.Now(), Up: true, Workers: p.workers}
+}
+
+// work is the loop of a worker. It runs tasks until the queue is
+// closed and empty, or until it has been idle for too long.
+func (p *Pool) work() {
+	defer p.wg.Done()
+	timer := time.NewTimer(p.opts.IdleTimeout)
+	defer timer.Stop()
+	for {
+		p.idle.Add(1)
+		select {
+		case task, ok := <-p.tasks:
+			p.idle.Add(-1)
+			if !ok {
+				p.mu.Lock()
+				p.workers--
+				p.mu.Unlock()
+				return
+			}
+			task()
+			p.completed.Add(1)
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			p.idle.Add(-1)
+			if p.retire() {
+				return
+			}
+		}
+		timer.Reset(p.opts.IdleTimeout)
+	}
+}
+
+// retire ends an idle worker, unless the pool is at its minimum size,
+// or a task has arrived in the meantime.
+func (p *Pool) retire() bool {
+	p.mu.Lock()
+	if p.workers <= p.opts.Min || len(p.tasks) > 0 {
+		p.mu.Unlock()
+		return false
+	}
+	p.workers--
+	p.scaleDowns++
+	close(p.retired)
+	p.retired = make(chan struct{})
+	ev := ScaleEvent{
+		Time:    time.Now(),
+		Workers: p.workers,
+		Queue:   len(p.tasks),
+		Reason:  fmt.Sprintf("idle for %s", p.opts.IdleTimeout),
+	}
+	p.mu.Unlock()
+	p.notify(ev)
+	return true
+}
+
+func (p *Pool) notify(ev ScaleEvent) {
+	if p.opts.OnScale != nil {
+		p.opts.OnScale(ev)
+	}
+}
+
+// Close stops accepting tasks, waits until the workers have run all
+// queued tasks, and then returns. Calling Close again has no effect
+// other than waiting.
+func (p *Pool) Close() {
+	p.closeMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+		// Queued tasks need a worker, even if all have retired.
+		p.mu.Lock()
+		if p.workers == 0 && len(p.tasks) > 0 {
+			p.startLocked()
+		}
+		p.mu.Unlock()
+		go func() {
+			p.wg.Wait()
+			close(p.done)
+		}()
+	}
+	p.closeMu.Unlock()
+	<-p.done
+}
+
+// Shutdown is like Close, but returns ctx.Err() if ctx is canceled
+// before all queued tasks have run. The workers continue to drain
+// the queue in the background.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Metrics returns the current metrics of the pool.
+func (p *Pool) Metrics() Metrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Metrics{
+		Workers:     p.workers,
+		Idle:        int(p.idle.Load()),
+		Queued:      len(p.tasks),
+		PeakWorkers: p.peak,
+		ScaleUps:    p.scaleUps,
+		ScaleDowns:  p.scaleDowns,
+		Submitted:   p.submitted.Load(),
+		Completed:   p.completed.Load(),
+	}
+}
@@ -0,0 +1,161 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScaleUpAndDown(t *testing.T) {
+	var ups, downs atomic.Int64
+	p := New(Options{
+		Min:         1,
+		Max:         4,
+		QueueSize:   10,
+		IdleTimeout: 20 * time.Millisecond,
+		OnScale: func(e ScaleEvent) {
+			if e.Up {
+				ups.Add(1)
+			} else {
+				downs.Add(1)
+			}
+		},
+	})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		if err := p.Submit(context.Background(), func() {
+			defer wg.Done()
+			time.Sleep(10 * time.Millisecond)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	m := p.Metrics()
+	if m.PeakWorkers != 4 || m.Completed != 20 || m.ScaleUps != 3 {
+		t.Errorf("after the burst: %+v", m)
+	}
+
+	// The extra workers retire.
+	deadline := time.Now().Add(time.Second)
+	for p.Metrics().Workers > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("workers did not retire: %+v", p.Metrics())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	m = p.Metrics()
+	if m.ScaleDowns != 3 || ups.Load() != 3 || downs.Load() != 3 {
+		t.Errorf("after idling: %+v, %d up and %d down events", m, ups.Load(), downs.Load())
+	}
+}
+
+func TestScaleUpAt(t *testing.T) {
+	p := New(Options{Min: 1, Max: 4, QueueSize: 10, ScaleUpAt: 5})
+	defer p.Close()
+	block := make(chan struct{})
+	started := make(chan struct{})
+	p.Submit(context.Background(), func() {
+		close(started)
+		<-block
+	})
+	<-started
+	for i := 0; i < 4; i++ {
+		p.Submit(context.Background(), func() { <-block })
+	}
+	// The worker is busy, four tasks wait: below the threshold.
+	if w := p.Metrics().Workers; w != 1 {
+		t.Errorf("got %d workers with 4 queued tasks, want 1", w)
+	}
+	p.Submit(context.Background(), func() { <-block })
+	if w := p.Metrics().Workers; w != 2 {
+		t.Errorf("got %d workers with 5 queued tasks, want 2", w)
+	}
+	close(block)
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	p := New(Options{Max: 2, QueueSize: 100})
+	var done atomic.Int64
+	for i := 0; i < 100; i++ {
+		p.Submit(context.Background(), func() {
+			time.Sleep(100 * time.Microsecond)
+			done.Add(1)
+		})
+	}
+	p.Close()
+	if n := done.Load(); n != 100 {
+		t.Errorf("%d of 100 tasks done after Close", n)
+	}
+	if err := p.Submit(context.Background(), func() {}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Close: got %v, want ErrClosed", err)
+	}
+	if m := p.Metrics(); m.Workers != 0 {
+		t.Errorf("%d workers left after Close", m.Workers)
+	}
+	p.Close() // again
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p := New(Options{Max: 1})
+	release := make(chan struct{})
+	p.Submit(context.Background(), func() { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+	close(release)
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubmitCanceled(t *testing.T) {
+	p := New(Options{Max: 1})
+	defer p.Close()
+	release := make(chan struct{})
+	defer close(release)
+	p.Submit(context.Background(), func() { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// The only worker is busy, and there is no queue.
+	if err := p.Submit(ctx, func() {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+}
+
+// With workers retiring all the time, no task may get stuck.
+func TestNoLostTasks(t *testing.T) {
+	for _, queue := range []int{0, 5} {
+		p := New(Options{Min: 0, Max: 3, QueueSize: queue, IdleTimeout: time.Microsecond})
+		var wg sync.WaitGroup
+		for i := 0; i < 1000; i++ {
+			wg.Add(1)
+			if err := p.Submit(context.Background(), wg.Done); err != nil {
+				t.Fatal(err)
+			}
+			if rand.Intn(10) == 0 {
+				time.Sleep(time.Duration(rand.Intn(50)) * time.Microsecond)
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("queue size %d: tasks stuck: %+v", queue, p.Metrics())
+		}
+		p.Close()
+	}
+}